@@ -0,0 +1,92 @@
+package xmlutils
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var charsetTests = []struct {
+	charset string
+	input   string
+	want    string
+}{
+	{"windows-1251", "\xcf\xf0\xe8\xe2\xe5\xf2", "Привет"},
+	{"CP1251", "\x88 \xb9", "€ №"},
+	{"windows-1252", "caf\xe9 \x80\x81", "café €�"},
+	{"koi8-r", "\xf0\xd2\xc9\xd7\xc5\xd4", "Привет"},
+	{"IBM866", "\x8f\xe0\xa8\xa2\xa5\xe2", "Привет"},
+	{"ISO-8859-1", "caf\xe9", "café"},
+	{"latin1", "\xff", "ÿ"},
+	{"ISO_8859-5", "\xbf\xe0\xd8\xd2\xd5\xe2", "Привет"},
+	{"iso-8859-15", "\xa4", "€"},
+	{"UTF-16LE", "\x1f\x04\x40\x04<\x00\x3d\xd8\x00\xde", "Пр<😀"},
+	{"UTF-16BE", "\x04\x1f\x04\x40\x00<", "Пр<"},
+	{"UTF-16", "\xff\xfe\x1f\x04<\x00", "П<"},
+	{"utf-16", "\x04\x1f\x00<", "П<"},
+	{"UTF-16LE", "\x00\xdc<\x00", "�<"},
+}
+
+func TestCharsetReader(t *testing.T) {
+	for _, tt := range charsetTests {
+		r, err := CharsetReader(tt.charset, iotest.OneByteReader(strings.NewReader(tt.input)))
+		if err != nil {
+			t.Errorf("%s: %v", tt.charset, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(iotest.HalfReader(r))
+		if err != nil {
+			t.Errorf("%s: %v", tt.charset, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.charset, got, tt.want)
+		}
+	}
+
+	if _, err := CharsetReader("x-unknown", strings.NewReader("")); err == nil || !strings.Contains(err.Error(), "x-unknown") {
+		t.Errorf("unknown charset: got error %v, want error naming the charset", err)
+	}
+	r, _ := CharsetReader("UTF-16LE", strings.NewReader("a\x00b"))
+	if _, err := ioutil.ReadAll(r); err == nil || err == io.EOF {
+		t.Errorf("truncated UTF-16: got error %v, want error", err)
+	}
+}
+
+// dataErrReader returns its data and err from a single Read.
+type dataErrReader struct {
+	data string
+	err  error
+}
+
+func (r *dataErrReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, r.err
+}
+
+func TestCharsetReaderError(t *testing.T) {
+	errRead := errors.New("read failed")
+	r, _ := CharsetReader("windows-1251", &dataErrReader{data: "\xcf\xf0", err: errRead})
+	got, err := ioutil.ReadAll(r)
+	if string(got) != "Пр" || err != errRead {
+		t.Errorf("ReadAll = %q, %v; want %q, %v", got, err, "Пр", errRead)
+	}
+}
+
+func TestDecodeLegacyCharset(t *testing.T) {
+	input := "<?xml version=\"1.0\" encoding=\"windows-1251\"?><name lang=\"\xf0\xf3\">\xcf\xf0\xe8\xe2\xe5\xf2</name>"
+	var v struct {
+		Lang string `xml:"lang,attr"`
+		Text string `xml:",chardata"`
+	}
+	if err := NewDecoder(strings.NewReader(input)).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Lang != "ру" || v.Text != "Привет" {
+		t.Errorf("got %+v, want lang ру and text Привет", v)
+	}
+}
@@ -0,0 +1,108 @@
+package xmlutils
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// An EncodeFunc returns the text representation of v, a value of the
+// type it was registered for.
+type EncodeFunc func(v interface{}) (string, error)
+
+// A DecodeFunc parses s into a value of the type it was registered for.
+type DecodeFunc func(s string) (interface{}, error)
+
+// codec holds the functions registered for a type. Either may be nil.
+type codec struct {
+	encode EncodeFunc
+	decode DecodeFunc
+}
+
+var codecs sync.Map // map[reflect.Type]*codec
+
+// RegisterCodec makes all encoders and decoders convert values of typ
+// with encode and decode instead of the usual rules. It is meant for
+// types that cannot implement Marshaler or Unmarshaler themselves.
+// The registered text is used for elements, attributes and character
+// data. Either function may be nil to leave that direction alone.
+//
+// Codecs registered on an Encoder or Decoder take precedence over the
+// ones registered with RegisterCodec.
+func RegisterCodec(typ reflect.Type, encode EncodeFunc, decode DecodeFunc) {
+	codecs.Store(typ, &codec{encode: encode, decode: decode})
+}
+
+// RegisterCodec makes the decoder convert values of typ with decode.
+// The decoder has no use for encode, which may be nil, as may decode
+// to leave the package-level codec in effect. See the package-level
+// RegisterCodec.
+func (d *Decoder) RegisterCodec(typ reflect.Type, encode EncodeFunc, decode DecodeFunc) {
+	if d.codecs == nil {
+		d.codecs = make(map[reflect.Type]*codec)
+	}
+	d.codecs[typ] = &codec{encode: encode, decode: decode}
+}
+
+// RegisterCodec makes the encoder convert values of typ with encode.
+// The encoder has no use for decode, which may be nil, as may encode
+// to leave the package-level codec in effect. See the package-level
+// RegisterCodec.
+func (enc *Encoder) RegisterCodec(typ reflect.Type, encode EncodeFunc, decode DecodeFunc) {
+	if enc.codecs == nil {
+		enc.codecs = make(map[reflect.Type]*codec)
+	}
+	enc.codecs[typ] = &codec{encode: encode, decode: decode}
+}
+
+// lookupCodec returns a codec value for val if one of local or the
+// package-level codecs has the function needed for encoding or, if
+// encode is false, decoding its type.
+func lookupCodec(local map[reflect.Type]*codec, val reflect.Value, encode bool) (codecValue, bool) {
+	if !val.IsValid() || !encode && !val.CanSet() {
+		return codecValue{}, false
+	}
+	typ := val.Type()
+	has := func(c *codec) bool {
+		if encode {
+			return c.encode != nil
+		}
+		return c.decode != nil
+	}
+	if c := local[typ]; c != nil && has(c) {
+		return codecValue{c, val}, true
+	}
+	if c, ok := codecs.Load(typ); ok && has(c.(*codec)) {
+		return codecValue{c.(*codec), val}, true
+	}
+	return codecValue{}, false
+}
+
+// A codecValue implements encoding.TextMarshaler and
+// encoding.TextUnmarshaler for a value of a registered type.
+type codecValue struct {
+	c *codec
+	v reflect.Value
+}
+
+func (cv codecValue) MarshalText() ([]byte, error) {
+	s, err := cv.c.encode(cv.v.Interface())
+	return []byte(s), err
+}
+
+func (cv codecValue) UnmarshalText(text []byte) error {
+	x, err := cv.c.decode(string(text))
+	if err != nil {
+		return err
+	}
+	xv := reflect.ValueOf(x)
+	if !xv.IsValid() {
+		cv.v.Set(reflect.Zero(cv.v.Type()))
+		return nil
+	}
+	if !xv.Type().AssignableTo(cv.v.Type()) {
+		return fmt.Errorf("xml: codec for %s decoded a value of type %s", cv.v.Type(), xv.Type())
+	}
+	cv.v.Set(xv)
+	return nil
+}
@@ -0,0 +1,106 @@
+package xmlutils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codecColor has no marshaling methods of its own.
+type codecColor struct {
+	R, G, B uint8
+}
+
+func init() {
+	RegisterCodec(reflect.TypeOf(codecColor{}),
+		func(v interface{}) (string, error) {
+			c := v.(codecColor)
+			return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B), nil
+		},
+		func(s string) (interface{}, error) {
+			var c codecColor
+			if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+				return nil, errors.New("bad color " + s)
+			}
+			return c, nil
+		})
+}
+
+type Palette struct {
+	XMLName  struct{}     `xml:"palette"`
+	Main     codecColor   `xml:"main,attr"`
+	Accent   *codecColor  `xml:"accent"`
+	Others   []codecColor `xml:"other"`
+	Server   net.IP       `xml:"server,attr"`
+	Fallback codecColor   `xml:",chardata"`
+}
+
+func TestCodec(t *testing.T) {
+	const data = `<palette main="#ff0000" server="10.0.0.1"><accent>#00ff00</accent><other>#000001</other><other>#000002</other>#0000ff</palette>`
+	want := Palette{
+		Main:     codecColor{255, 0, 0},
+		Accent:   &codecColor{0, 255, 0},
+		Others:   []codecColor{{0, 0, 1}, {0, 0, 2}},
+		Server:   net.ParseIP("10.0.0.1"),
+		Fallback: codecColor{0, 0, 255},
+	}
+
+	var got Palette
+	if err := Unmarshal([]byte(data), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal:\nhave %+v\nwant %+v", got, want)
+	}
+
+	out, err := Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, data)
+	}
+
+	// Codecs on a Decoder or Encoder win over the ones of the package
+	// and over the methods of the type.
+	d := NewDecoder(strings.NewReader(data))
+	d.RegisterCodec(reflect.TypeOf(net.IP{}), nil, func(s string) (interface{}, error) {
+		return net.ParseIP("127.0.0.1"), nil
+	})
+	got = Palette{}
+	if err := d.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Server.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("Decoder codec: Server = %v, want 127.0.0.1", got.Server)
+	}
+
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.RegisterCodec(reflect.TypeOf(net.IP{}), func(v interface{}) (string, error) {
+		return "hidden", nil
+	}, nil)
+	if err := enc.Encode(&want); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `server="hidden"`) {
+		t.Errorf("Encoder codec: got %s", b.String())
+	}
+
+	err = Unmarshal([]byte(`<palette main="red"/>`), &got)
+	if err == nil || err.Error() != "bad color red" {
+		t.Errorf("bad value: got error %v, want bad color red", err)
+	}
+
+	d = NewDecoder(strings.NewReader(`<palette><accent>#00ff00</accent></palette>`))
+	d.RegisterCodec(reflect.TypeOf(codecColor{}), nil, func(s string) (interface{}, error) {
+		return s, nil
+	})
+	if err := d.Decode(&got); err == nil || !strings.Contains(err.Error(), "decoded a value of type string") {
+		t.Errorf("wrong type: got error %v", err)
+	}
+}
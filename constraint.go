@@ -0,0 +1,138 @@
+package xmlutils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A FixedValueError reports a value that differs from the value fixed
+// for its field by the fixed tag option.
+type FixedValueError struct {
+	Value string
+	Fixed string
+}
+
+func (e *FixedValueError) Error() string {
+	return fmt.Sprintf("value %q does not match fixed value %q", e.Value, e.Fixed)
+}
+
+// isFixedBlank reports whether f is a blank field carrying a fixed
+// value, as in _ struct{} `xmlutils:"version,attr,fixed=1.0"`. Such
+// fields are encoded although they are not exported. The xmlutils key
+// keeps go vet from reporting an xml tag on an unexported field.
+func isFixedBlank(f *reflect.StructField) bool {
+	if f.Name != "_" {
+		return false
+	}
+	for _, flag := range strings.Split(fieldTag(f), ",")[1:] {
+		if strings.HasPrefix(flag, "fixed=") {
+			return true
+		}
+	}
+	return false
+}
+
+// An EnumValueError reports a value that is not one of the values
+// allowed for its field by the enum tag option.
+type EnumValueError struct {
+	Value   string
+	Allowed []string
+}
+
+func (e *EnumValueError) Error() string {
+	allowed := make([]string, len(e.Allowed))
+	for i, a := range e.Allowed {
+		allowed[i] = strconv.Quote(a)
+	}
+	return fmt.Sprintf("value %q is not one of %s", e.Value, strings.Join(allowed, ", "))
+}
+
+// constrained reports whether finfo restricts the text of its value.
+func (finfo *fieldInfo) constrained() bool {
+	return finfo.hasFixed || finfo.enum != nil
+}
+
+// check returns an error if text violates the constraints of finfo.
+// Surrounding white space is ignored, as it is for numbers and bools.
+func (finfo *fieldInfo) check(text string) error {
+	text = strings.TrimSpace(text)
+	if finfo.hasFixed && text != finfo.fixed {
+		return &FixedValueError{Value: text, Fixed: finfo.fixed}
+	}
+	if finfo.enum != nil {
+		for _, v := range finfo.enum {
+			if text == v {
+				return nil
+			}
+		}
+		return &EnumValueError{Value: text, Allowed: finfo.enum}
+	}
+	return nil
+}
+
+// checkField reports whether text satisfies the constraints of finfo.
+// A violation is recorded if CollectErrors is set, and returned
+// annotated with the path of the value otherwise.
+func (d *Decoder) checkField(finfo *fieldInfo, text string, pos Position, names ...string) (bool, error) {
+	err := finfo.check(text)
+	if err == nil {
+		return true, nil
+	}
+	return false, d.fail(err, pos, names...)
+}
+
+// marshalFixed writes the element holding the fixed value of finfo,
+// after checking that val, the value of the field, is empty or agrees
+// with it.
+func (p *printer) marshalFixed(finfo *fieldInfo, val reflect.Value, startTemplate *xml.StartElement) error {
+	start := xml.StartElement{Name: xml.Name{Space: finfo.xmlns, Local: finfo.name}}
+	if startTemplate != nil {
+		start.Name = startTemplate.Name
+		start.Attr = append(start.Attr, startTemplate.Attr...)
+	} else {
+		finfo.addPredicateAttr(&start)
+	}
+	if err := p.checkField(finfo, val, start.Name.Local); err != nil {
+		return err
+	}
+	if err := p.writeStart(&start); err != nil {
+		return err
+	}
+	p.EscapeString(finfo.fixed)
+	return p.writeEnd(start.Name)
+}
+
+// checkField returns an error annotated with the path of the value if
+// val, the value of the field finfo, violates its constraints. Names
+// extend the path of the open elements. Values that have no text, and
+// empty values of fixed fields, which encode the fixed value, pass.
+func (p *printer) checkField(finfo *fieldInfo, val reflect.Value, names ...string) error {
+	if !finfo.constrained() || finfo.blank || !val.IsValid() || finfo.hasFixed && val.IsZero() {
+		return nil
+	}
+	text, ok := p.fieldText(finfo, val)
+	if !ok {
+		return nil
+	}
+	if err := finfo.check(text); err != nil {
+		return &PathError{Path: p.path(names...), Err: err}
+	}
+	return nil
+}
+
+// path returns the path of the open elements extended by names.
+func (p *printer) path(names ...string) string {
+	var b strings.Builder
+	for _, name := range p.tags {
+		b.WriteByte('/')
+		b.WriteString(name.Local)
+	}
+	for _, name := range names {
+		b.WriteByte('/')
+		b.WriteString(name)
+	}
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package xmlutils
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type FixedDoc struct {
+	XMLName struct{} `xml:"doc"`
+	Version string   `xml:"version,attr,fixed=1.0"`
+	Kind    string   `xml:"kind,attr,fixed=invoice"`
+	Schema  string   `xml:"head>schema,fixed=urn:inv"`
+	Number  int      `xml:"number"`
+}
+
+func TestMarshalFixed(t *testing.T) {
+	const want = `<doc version="1.0" kind="invoice"><head><schema>urn:inv</schema></head><number>7</number></doc>`
+	for _, v := range []FixedDoc{
+		{Number: 7},
+		{Kind: "invoice", Schema: "urn:inv", Number: 7},
+	} {
+		out, err := Marshal(&v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != want {
+			t.Errorf("Marshal(%+v):\nhave %s\nwant %s", v, out, want)
+		}
+	}
+
+	// OmitDefaults does not drop fixed values.
+	var b strings.Builder
+	enc := NewEncoder(&b)
+	enc.OmitDefaults = true
+	if err := enc.Encode(&FixedDoc{Number: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want {
+		t.Errorf("Encode with OmitDefaults:\nhave %s\nwant %s", b.String(), want)
+	}
+
+	for _, v := range []FixedDoc{{Kind: "receipt"}, {Schema: "urn:other"}} {
+		_, err := Marshal(&v)
+		var fe *FixedValueError
+		if !errors.As(err, &fe) {
+			t.Errorf("Marshal(%+v): got error %v, want *FixedValueError", v, err)
+		}
+	}
+}
+
+func TestUnmarshalFixed(t *testing.T) {
+	var v FixedDoc
+	if err := Unmarshal([]byte(`<doc><number>3</number></doc>`), &v); err != nil {
+		t.Fatal(err)
+	}
+	want := FixedDoc{Version: "1.0", Kind: "invoice", Schema: "urn:inv", Number: 3}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("absent fixed values: have %+v, want %+v", v, want)
+	}
+
+	tests := []struct {
+		input string
+		path  string
+		value string
+	}{
+		{`<doc version="2.0"/>`, "/doc/@version", "2.0"},
+		{`<doc kind="receipt"/>`, "/doc/@kind", "receipt"},
+		{`<doc><head><schema>urn:x</schema></head></doc>`, "/doc/head/schema", "urn:x"},
+	}
+	for _, tt := range tests {
+		var v FixedDoc
+		err := Unmarshal([]byte(tt.input), &v)
+		var pe *PathError
+		if !errors.As(err, &pe) {
+			t.Errorf("Unmarshal(%s): got error %v, want *PathError", tt.input, err)
+			continue
+		}
+		if pe.Path != tt.path {
+			t.Errorf("Unmarshal(%s): error path %q, want %q", tt.input, pe.Path, tt.path)
+		}
+		var fe *FixedValueError
+		if !errors.As(err, &fe) || fe.Value != tt.value {
+			t.Errorf("Unmarshal(%s): got error %v, want *FixedValueError for %q", tt.input, err, tt.value)
+		}
+	}
+
+	d := NewDecoder(strings.NewReader(`<doc version="2.0" kind="receipt"><number>5</number></doc>`))
+	d.CollectErrors = true
+	v = FixedDoc{}
+	errs, ok := d.Decode(&v).(ErrorList)
+	if !ok || len(errs) != 2 || v.Number != 5 {
+		t.Errorf("CollectErrors: got %v, Number = %d", errs, v.Number)
+	}
+}
+
+type FixedBlankDoc struct {
+	XMLName struct{} `xml:"doc"`
+	_       struct{} `xmlutils:"version,attr,fixed=1.0"`
+	Number  int      `xml:"number"`
+}
+
+func TestFixedBlankField(t *testing.T) {
+	out, err := Marshal(&FixedBlankDoc{Number: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<doc version="1.0"><number>1</number></doc>`; string(out) != want {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, want)
+	}
+
+	var v FixedBlankDoc
+	if err := Unmarshal(out, &v); err != nil || v.Number != 1 {
+		t.Errorf("Unmarshal = %+v, %v", v, err)
+	}
+	var fe *FixedValueError
+	if err := Unmarshal([]byte(`<doc version="2.0"/>`), &v); !errors.As(err, &fe) {
+		t.Errorf("Unmarshal mismatch: got error %v, want *FixedValueError", err)
+	}
+}
+
+func TestFixedInvalidTag(t *testing.T) {
+	var v struct {
+		Text string `xml:",chardata,fixed=x"`
+	}
+	if err := Unmarshal([]byte(`<x/>`), &v); err == nil {
+		t.Errorf("fixed option on chardata: want error")
+	}
+}
+
+type EnumOrder struct {
+	XMLName struct{} `xml:"order"`
+	Status  string   `xml:"status,attr,enum=new|paid|cancelled"`
+	Lines   []int    `xml:"lines>qty,enum=1|2|5"`
+	Channel *string  `xml:"channel,enum=web|shop"`
+}
+
+func TestUnmarshalEnum(t *testing.T) {
+	var v EnumOrder
+	input := `<order status=" paid "><lines><qty>1</qty><qty> 5 </qty></lines><channel>web</channel></order>`
+	if err := Unmarshal([]byte(input), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != " paid " || !reflect.DeepEqual(v.Lines, []int{1, 5}) || v.Channel == nil || *v.Channel != "web" {
+		t.Errorf("Unmarshal = %+v", v)
+	}
+
+	tests := []struct {
+		input string
+		path  string
+		value string
+	}{
+		{`<order status="lost"/>`, "/order/@status", "lost"},
+		{`<order><lines><qty>1</qty><qty>3</qty></lines></order>`, "/order/lines/qty", "3"},
+		{`<order><channel>phone</channel></order>`, "/order/channel", "phone"},
+	}
+	for _, tt := range tests {
+		var v EnumOrder
+		err := Unmarshal([]byte(tt.input), &v)
+		var pe *PathError
+		if !errors.As(err, &pe) || pe.Path != tt.path {
+			t.Errorf("Unmarshal(%s): got error %v, want path %q", tt.input, err, tt.path)
+			continue
+		}
+		var ee *EnumValueError
+		if !errors.As(err, &ee) || ee.Value != tt.value || len(ee.Allowed) == 0 {
+			t.Errorf("Unmarshal(%s): got error %v, want *EnumValueError for %q", tt.input, err, tt.value)
+		}
+	}
+}
+
+func TestMarshalEnum(t *testing.T) {
+	web := "web"
+	out, err := Marshal(&EnumOrder{Status: "new", Lines: []int{2}, Channel: &web})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `<order status="new"><lines><qty>2</qty></lines><channel>web</channel></order>`
+	if string(out) != want {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, want)
+	}
+
+	phone := "phone"
+	tests := []struct {
+		v    EnumOrder
+		path string
+	}{
+		{EnumOrder{Status: "lost"}, "/order/@status"},
+		{EnumOrder{Status: "new", Lines: []int{1, 4}}, "/order/lines/qty"},
+		{EnumOrder{Status: "new", Channel: &phone}, "/order/channel"},
+	}
+	for _, tt := range tests {
+		_, err := Marshal(&tt.v)
+		var pe *PathError
+		var ee *EnumValueError
+		if !errors.As(err, &pe) || pe.Path != tt.path || !errors.As(err, &ee) {
+			t.Errorf("Marshal(%+v): got error %v, want *EnumValueError at %q", tt.v, err, tt.path)
+		}
+	}
+
+	_, err = Marshal(&EnumOrder{Status: "lost"})
+	if want := `xml: /order/@status: value "lost" is not one of "new", "paid", "cancelled"`; err == nil || err.Error() != want {
+		t.Errorf("error text %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,73 @@
+package xmlutils
+
+import (
+	"reflect"
+)
+
+// AfterUnmarshaler is the interface implemented by types that check or
+// normalize themselves once they have been decoded.
+//
+// AfterUnmarshalXML is called for a struct decoded by the usual rules,
+// after its element has been consumed entirely. It is not called for
+// types implementing Unmarshaler. A returned error is annotated with
+// the path of the element.
+type AfterUnmarshaler interface {
+	AfterUnmarshalXML() error
+}
+
+// BeforeMarshaler is the interface implemented by types that check or
+// normalize themselves before they are encoded.
+//
+// BeforeMarshalXML is called for a struct encoded by the usual rules,
+// before its element is written. It is not called for types implementing
+// Marshaler. A returned error is annotated with the path of the element.
+// Methods with a pointer receiver are only called for addressable values,
+// so pass a pointer to Marshal.
+type BeforeMarshaler interface {
+	BeforeMarshalXML() error
+}
+
+var (
+	afterUnmarshalerType = reflect.TypeOf((*AfterUnmarshaler)(nil)).Elem()
+	beforeMarshalerType  = reflect.TypeOf((*BeforeMarshaler)(nil)).Elem()
+)
+
+// hook returns the value of val or its address implementing typ.
+func hook(val reflect.Value, typ reflect.Type) (interface{}, bool) {
+	if val.CanInterface() && val.Type().Implements(typ) {
+		return val.Interface(), true
+	}
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(typ) {
+			return pv.Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// afterUnmarshal calls the AfterUnmarshalXML method of the struct sv
+// decoded from the element named name, if it has one.
+func (d *Decoder) afterUnmarshal(sv reflect.Value, pos Position, name string) error {
+	h, ok := hook(sv, afterUnmarshalerType)
+	if !ok {
+		return nil
+	}
+	if err := h.(AfterUnmarshaler).AfterUnmarshalXML(); err != nil {
+		return d.fail(err, pos, name)
+	}
+	return nil
+}
+
+// beforeMarshal calls the BeforeMarshalXML method of the struct val
+// about to be encoded as the element named name, if it has one.
+func (p *printer) beforeMarshal(val reflect.Value, name string) error {
+	h, ok := hook(val, beforeMarshalerType)
+	if !ok {
+		return nil
+	}
+	if err := h.(BeforeMarshaler).BeforeMarshalXML(); err != nil {
+		return &PathError{Path: p.path(name), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package xmlutils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type HookLine struct {
+	SKU string `xml:"sku,attr"`
+	Qty int    `xml:"qty"`
+}
+
+func (l *HookLine) AfterUnmarshalXML() error {
+	l.SKU = strings.ToUpper(strings.TrimSpace(l.SKU))
+	if l.Qty <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	return nil
+}
+
+func (l *HookLine) BeforeMarshalXML() error {
+	if l.SKU == "" {
+		return errors.New("missing sku")
+	}
+	l.SKU = strings.ToUpper(l.SKU)
+	return nil
+}
+
+type HookOrder struct {
+	XMLName struct{}   `xml:"order"`
+	Lines   []HookLine `xml:"line"`
+	calls   int
+}
+
+func (o *HookOrder) AfterUnmarshalXML() error {
+	o.calls++
+	return nil
+}
+
+func TestAfterUnmarshal(t *testing.T) {
+	var v HookOrder
+	err := Unmarshal([]byte(`<order><line sku=" a1 "><qty>2</qty></line><line sku="b2"><qty>1</qty></line></order>`), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Lines) != 2 || v.Lines[0].SKU != "A1" || v.Lines[1].SKU != "B2" {
+		t.Errorf("lines were not normalized: %+v", v.Lines)
+	}
+	if v.calls != 1 {
+		t.Errorf("AfterUnmarshalXML called %d times, want 1", v.calls)
+	}
+
+	const bad = `<order><line sku="a"><qty>0</qty></line></order>`
+	err = Unmarshal([]byte(bad), &HookOrder{})
+	var pe *PathError
+	if !errors.As(err, &pe) || pe.Path != "/order/line" {
+		t.Errorf("Unmarshal: got error %v, want path /order/line", err)
+	}
+
+	d := NewDecoder(strings.NewReader(bad))
+	d.CollectErrors = true
+	if errs, ok := d.Decode(&HookOrder{}).(ErrorList); !ok || len(errs) != 1 {
+		t.Errorf("CollectErrors: got %v", errs)
+	}
+}
+
+func TestBeforeMarshal(t *testing.T) {
+	out, err := Marshal(&HookOrder{Lines: []HookLine{{SKU: "a1", Qty: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `<order><line sku="A1"><qty>2</qty></line></order>`
+	if string(out) != want {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, want)
+	}
+
+	_, err = Marshal(&HookOrder{Lines: []HookLine{{Qty: 1}}})
+	var pe *PathError
+	if !errors.As(err, &pe) || pe.Path != "/order/line" || pe.Err.Error() != "missing sku" {
+		t.Errorf("Marshal: got error %v, want missing sku at /order/line", err)
+	}
+}
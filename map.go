@@ -0,0 +1,197 @@
+package xmlutils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Maps with string keys are encoded in one of three ways:
+//
+//   - By default the map is an element holding one child element per
+//     entry, named by the key: <props><color>red</color></props>.
+//   - With the key option, as in `xml:"entry,key=id"`, every entry is an
+//     element of the field's name with the key in the given attribute,
+//     "key" if none is given: <entry id="color">red</entry>.
+//   - With the any option, the entries are the child elements of the
+//     enclosing element, named by the key. With any,attr on a
+//     map[string]string, the entries are the attributes of the enclosing
+//     element that no other field handles, keyed by their local name.
+//
+// Entries are encoded in key order.
+
+// isStringMap reports whether typ, or what it points to, is a map with
+// string keys.
+func isStringMap(typ reflect.Type) bool {
+	typ = baseType(typ)
+	return typ.Kind() == reflect.Map && typ.Key().Kind() == reflect.String
+}
+
+// sortedMapKeys returns the keys of the map m in increasing order.
+func sortedMapKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+// marshalMapEntries writes the entries of the map val as elements
+// named by their keys, or named by finfo with the key in an attribute
+// if finfo has the key option.
+func (p *printer) marshalMapEntries(val reflect.Value, finfo *fieldInfo) error {
+	if val.Type().Key().Kind() != reflect.String {
+		return &xml.UnsupportedTypeError{Type: val.Type()}
+	}
+	for _, k := range sortedMapKeys(val) {
+		var start xml.StartElement
+		if finfo != nil && finfo.mapKey != "" {
+			start.Name = xml.Name{Space: finfo.xmlns, Local: finfo.name}
+			start.Attr = []xml.Attr{{Name: xml.Name{Local: finfo.mapKey}, Value: k.String()}}
+		} else {
+			if !isNameString(k.String()) {
+				return &UnsupportedMapKeyError{Key: k.String()}
+			}
+			start.Name.Local = k.String()
+		}
+		if err := p.marshalValue(val.MapIndex(k), nil, &start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// marshalMapAttrs adds the entries of the map val to start as
+// attributes named by their keys.
+func (p *printer) marshalMapAttrs(start *xml.StartElement, val reflect.Value) error {
+	for _, k := range sortedMapKeys(val) {
+		if !isNameString(k.String()) {
+			return &UnsupportedMapKeyError{Key: k.String()}
+		}
+		if err := p.marshalAttr(start, xml.Name{Local: k.String()}, val.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An UnsupportedMapKeyError is returned when a map key cannot be used
+// as an element or attribute name.
+type UnsupportedMapKeyError struct {
+	Key string
+}
+
+func (e *UnsupportedMapKeyError) Error() string {
+	return fmt.Sprintf("xml: map key %q is not a valid XML name", e.Key)
+}
+
+// mapValue returns the map held by val, allocating it if needed.
+func mapValue(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+	if val.IsNil() {
+		val.Set(reflect.MakeMap(val.Type()))
+	}
+	return val
+}
+
+// mapElem returns a settable copy of the entry of m for key, so that
+// repeated keys add to slices rather than replace them.
+func mapElem(m, key reflect.Value) reflect.Value {
+	elem := reflect.New(m.Type().Elem()).Elem()
+	if old := m.MapIndex(key); old.IsValid() {
+		elem.Set(old)
+	}
+	return elem
+}
+
+// unmarshalMap reads the child elements of start into the map val,
+// keyed by their names.
+func (d *Decoder) unmarshalMap(val reflect.Value, start *xml.StartElement) error {
+	m := mapValue(val)
+	if d.Unconsumed != nil {
+		for _, a := range start.Attr {
+			d.skippedAttr(a)
+		}
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			key := reflect.ValueOf(t.Name.Local).Convert(m.Type().Key())
+			elem := mapElem(m, key)
+			if err := d.unmarshal(elem, &t); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// unmarshalMapEntry reads the element start into the map val as a
+// single entry. The key is the value of the key attribute given by
+// finfo, or else the element name.
+func (d *Decoder) unmarshalMapEntry(finfo *fieldInfo, val reflect.Value, start *xml.StartElement) error {
+	m := mapValue(val)
+	key := start.Name.Local
+	if finfo.mapKey != "" {
+		found := false
+		entry := *start
+		entry.Attr = nil
+		for _, a := range start.Attr {
+			if !found && a.Name.Local == finfo.mapKey {
+				key, found = a.Value, true
+				continue
+			}
+			entry.Attr = append(entry.Attr, a)
+		}
+		if !found {
+			return d.reject(UnmarshalError("element <" + start.Name.Local + "> has no " + finfo.mapKey + " attribute for its map key"))
+		}
+		start = &entry
+	}
+	k := reflect.ValueOf(key).Convert(m.Type().Key())
+	elem := mapElem(m, k)
+	if err := d.unmarshal(elem, start); err != nil {
+		return err
+	}
+	m.SetMapIndex(k, elem)
+	return nil
+}
+
+// localNameClash reports whether an attribute of start other than the
+// i-th, and other than a name space declaration, has the same local
+// name as the i-th in a different name space. Map keys hold only the
+// local name, so such attributes cannot both be stored.
+func localNameClash(start *xml.StartElement, i int) bool {
+	a := start.Attr[i]
+	for j, b := range start.Attr {
+		if j != i && b.Name.Local == a.Name.Local && b.Name.Space != a.Name.Space && b.Name.Space != xmlnsPrefix {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalMapAttr stores attr in the map val, keyed by its local name.
+// Name space declarations are left out.
+func (d *Decoder) unmarshalMapAttr(val reflect.Value, attr xml.Attr) error {
+	if attr.Name.Space == xmlnsPrefix || attr.Name.Space == "" && attr.Name.Local == xmlnsPrefix {
+		return nil
+	}
+	m := mapValue(val)
+	elem := reflect.New(m.Type().Elem()).Elem()
+	if err := d.unmarshalAttr(elem, attr); err != nil {
+		return err
+	}
+	m.SetMapIndex(reflect.ValueOf(attr.Name.Local).Convert(m.Type().Key()), elem)
+	return nil
+}
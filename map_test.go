@@ -0,0 +1,90 @@
+package xmlutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type MapItem struct {
+	Qty int `xml:"qty,attr"`
+}
+
+type MapDoc struct {
+	XMLName struct{}            `xml:"doc"`
+	ID      string              `xml:"id,attr"`
+	Extra   map[string]string   `xml:",any,attr"`
+	Props   map[string]string   `xml:"props"`
+	Items   map[string]MapItem  `xml:"item,key=sku"`
+	Labels  map[string]string   `xml:"label,key"`
+	Rest    map[string][]string `xml:",any"`
+}
+
+func TestMapRoundTrip(t *testing.T) {
+	const data = `<doc id="1" a="x" b="y">` +
+		`<props><color>red</color><size>L</size></props>` +
+		`<item sku="A1" qty="2"></item><item sku="B2" qty="5"></item>` +
+		`<label key="en">Hello</label><label key="ru">Привет</label>` +
+		`<note>one</note><note>two</note><tag>t</tag>` +
+		`</doc>`
+	want := MapDoc{
+		ID:     "1",
+		Extra:  map[string]string{"a": "x", "b": "y"},
+		Props:  map[string]string{"color": "red", "size": "L"},
+		Items:  map[string]MapItem{"A1": {2}, "B2": {5}},
+		Labels: map[string]string{"en": "Hello", "ru": "Привет"},
+		Rest:   map[string][]string{"note": {"one", "two"}, "tag": {"t"}},
+	}
+
+	var got MapDoc
+	d := NewDecoder(strings.NewReader(`<doc xmlns:z="urn:z"` + data[len("<doc"):]))
+	if err := d.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmarshal:\nhave %+v\nwant %+v", got, want)
+	}
+
+	// Encoding the same maps many times gives the same output.
+	for i := 0; i < 5; i++ {
+		out, err := Marshal(&want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != data {
+			t.Fatalf("Marshal:\nhave %s\nwant %s", out, data)
+		}
+	}
+}
+
+func TestMapErrors(t *testing.T) {
+	_, err := Marshal(&MapDoc{Props: map[string]string{"bad key": "v"}})
+	if _, ok := err.(*UnsupportedMapKeyError); !ok {
+		t.Errorf("invalid key: got error %v, want *UnsupportedMapKeyError", err)
+	}
+
+	var v MapDoc
+	err = Unmarshal([]byte(`<doc><item qty="1"/></doc>`), &v)
+	if err == nil || !strings.Contains(err.Error(), "no sku attribute") {
+		t.Errorf("missing key: got error %v", err)
+	}
+
+	var bad struct {
+		M []string `xml:"m,key=id"`
+	}
+	if err := Unmarshal([]byte(`<x/>`), &bad); err == nil {
+		t.Errorf("key option on slice: want error")
+	}
+
+	var ints struct {
+		M map[int]string `xml:"m"`
+	}
+	if err := Unmarshal([]byte(`<x><m><a>1</a></m></x>`), &ints); err == nil {
+		t.Errorf("int keys: want error")
+	}
+
+	err = Unmarshal([]byte(`<doc xmlns:p="urn:p" xmlns:q="urn:q" p:a="1" q:a="2"/>`), &v)
+	if err == nil || !strings.Contains(err.Error(), "more than one name space") {
+		t.Errorf("clashing attributes: got error %v", err)
+	}
+}
@@ -14,6 +14,8 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -135,12 +137,55 @@ func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
 
 // An Encoder writes XML data to an output stream.
 type Encoder struct {
-	p printer
+	// SelfClosing, if true, makes the encoder write an element that
+	// has no content in the self-closing form <a/> instead of <a></a>.
+	// The start tag of every element is then held back until the next
+	// token shows whether the element is empty, so Flush may leave the
+	// most recent start tag incomplete.
+	SelfClosing bool
+
+	// OmitDefaults, if true, leaves out the elements and attributes
+	// of fields with a default tag option whose value encodes to the
+	// default, since decoding restores it.
+	OmitDefaults bool
+
+	// Declaration, if non-nil, is written as the XML declaration in
+	// front of the first token, followed by a newline. It is not
+	// written if the first token is an XML declaration itself.
+	// Encoding fails if the version, encoding or standalone value
+	// is not one the XML specification allows.
+	Declaration *Declaration
+
+	// CharsetWriter, if non-nil, defines a function to generate
+	// charset-conversion writers, converting from UTF-8 into the
+	// provided non-UTF-8 charset. The charset is the encoding named
+	// by the XML declaration, either Declaration or an xml ProcInst
+	// passed to EncodeToken, so the declaration always matches the
+	// output. If a non-UTF-8 encoding is declared and CharsetWriter
+	// is nil or returns an error, encoding stops with an error.
+	//
+	// If the returned writer implements RuneEncoder, characters it
+	// cannot represent are written as numeric character references in
+	// character data and attribute values. Elsewhere, as in names,
+	// comments, CDATA sections and processing instructions, such
+	// characters make encoding fail.
+	CharsetWriter func(charset string, output io.Writer) (io.Writer, error)
+
+	codecs       map[reflect.Type]*codec
+	xsiTypeNames map[reflect.Type]xml.Name
+	p            printer
+}
+
+// A RuneEncoder is implemented by writers returned from
+// Encoder.CharsetWriter that can tell which characters their
+// charset is able to represent.
+type RuneEncoder interface {
+	CanEncode(r rune) bool
 }
 
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
-	e := &Encoder{printer{Writer: bufio.NewWriter(w)}}
+	e := &Encoder{p: printer{Writer: bufio.NewWriter(w), output: w}}
 	e.p.encoder = e
 	return e
 }
@@ -160,6 +205,9 @@ func (enc *Encoder) Indent(prefix, indent string) {
 //
 // Encode calls Flush before returning.
 func (enc *Encoder) Encode(v interface{}) error {
+	if err := enc.begin(nil); err != nil {
+		return err
+	}
 	err := enc.p.marshalValue(reflect.ValueOf(v), nil, nil)
 	if err != nil {
 		return err
@@ -175,6 +223,9 @@ func (enc *Encoder) Encode(v interface{}) error {
 //
 // EncodeElement calls Flush before returning.
 func (enc *Encoder) EncodeElement(v interface{}, start xml.StartElement) error {
+	if err := enc.begin(nil); err != nil {
+		return err
+	}
 	err := enc.p.marshalValue(reflect.ValueOf(v), nil, &start)
 	if err != nil {
 		return err
@@ -201,6 +252,9 @@ var (
 // EncodeToken allows writing a ProcInst with Target set to "xml" only as the first token
 // in the stream.
 func (enc *Encoder) EncodeToken(t xml.Token) error {
+	if err := enc.begin(t); err != nil {
+		return err
+	}
 
 	p := &enc.p
 	switch t := t.(type) {
@@ -241,6 +295,11 @@ func (enc *Encoder) EncodeToken(t xml.Token) error {
 			p.Write(t.Inst)
 		}
 		p.WriteString("?>")
+		if t.Target == "xml" {
+			if err := p.switchCharset(procInst("encoding", string(t.Inst))); err != nil {
+				return err
+			}
+		}
 	case xml.Directive:
 		if !isValidDirective(t) {
 			return fmt.Errorf("xml: EncodeToken of Directive containing wrong < or > markers")
@@ -255,6 +314,28 @@ func (enc *Encoder) EncodeToken(t xml.Token) error {
 	return p.cachedWriteError()
 }
 
+// begin writes the configured XML declaration before the first token t
+// is encoded. t is nil when the first token is not known yet.
+func (enc *Encoder) begin(t xml.Token) error {
+	p := &enc.p
+	if p.begun {
+		return nil
+	}
+	if pi, ok := t.(xml.ProcInst); enc.Declaration == nil || ok && pi.Target == "xml" {
+		p.begun = true
+		return nil
+	}
+	if err := enc.Declaration.validate(); err != nil {
+		return err
+	}
+	p.begun = true
+	if err := enc.EncodeToken(enc.Declaration.ProcInst()); err != nil {
+		return err
+	}
+	p.WriteByte('\n')
+	return p.cachedWriteError()
+}
+
 // isValidDirective reports whether dir is a valid directive text,
 // meaning angle brackets are matched, ignoring comments and strings.
 func isValidDirective(dir xml.Directive) bool {
@@ -314,6 +395,139 @@ type printer struct {
 	attrPrefix map[string]string // map name space -> prefix
 	prefixes   []string
 	tags       []xml.Name
+	openStart  bool      // start tag written without its closing '>'
+	begun      bool      // first token has been encoded
+	xsiType    *xml.Name // xsi:type for the next start tag
+	declareXSI bool      // declare the xsi prefix in the next start tag
+	output     io.Writer
+	charset    *charsetWriter // output conversion, if it has a RuneEncoder
+}
+
+// Write writes b to the output, first completing a start tag
+// held back by writeStart. WriteString and WriteByte do the same.
+func (p *printer) Write(b []byte) (int, error) {
+	if p.openStart && len(b) > 0 {
+		p.closeStart()
+	}
+	return p.Writer.Write(b)
+}
+
+func (p *printer) WriteString(s string) (int, error) {
+	if p.openStart && len(s) > 0 {
+		p.closeStart()
+	}
+	return p.Writer.WriteString(s)
+}
+
+func (p *printer) WriteByte(c byte) error {
+	if p.openStart {
+		p.closeStart()
+	}
+	return p.Writer.WriteByte(c)
+}
+
+// switchCharset makes the rest of the output go through a writer
+// converting it into charset, as obtained from the CharsetWriter
+// of the encoder.
+func (p *printer) switchCharset(charset string) error {
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "utf8") {
+		return nil
+	}
+	if p.encoder.CharsetWriter == nil {
+		return fmt.Errorf("xml: encoding %q declared but Encoder.CharsetWriter is nil", charset)
+	}
+	if err := p.Writer.Flush(); err != nil {
+		return err
+	}
+	w, err := p.encoder.CharsetWriter(charset, p.output)
+	if err != nil {
+		return fmt.Errorf("xml: opening charset %q: %v", charset, err)
+	}
+	if w == nil {
+		panic("CharsetWriter returned a nil Writer for charset " + charset)
+	}
+	if re, ok := w.(RuneEncoder); ok {
+		p.charset = &charsetWriter{w: w, enc: re}
+		w = p.charset
+	}
+	p.Writer.Reset(w)
+	return nil
+}
+
+// Flush writes the buffered output to the underlying writer.
+func (p *printer) Flush() error {
+	if err := p.Writer.Flush(); err != nil {
+		return err
+	}
+	if p.charset != nil {
+		return p.charset.flush()
+	}
+	return nil
+}
+
+// A charsetWriter checks that its underlying writer can encode the
+// characters written to it. The escaping of character data and
+// attribute values has already replaced those it cannot encode with
+// numeric character references, which are not allowed elsewhere.
+type charsetWriter struct {
+	w       io.Writer
+	enc     RuneEncoder
+	partial []byte // incomplete UTF-8 sequence from the previous Write
+}
+
+func (c *charsetWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if len(c.partial) > 0 {
+		b = append(c.partial, b...)
+		c.partial = nil
+	}
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 && !utf8.FullRune(b[i:]) {
+			c.partial = append(c.partial, b[i:]...)
+			b = b[:i]
+			break
+		}
+		if r >= utf8.RuneSelf && !c.enc.CanEncode(r) {
+			return 0, fmt.Errorf("xml: character %U cannot be encoded in the output charset outside character data and attribute values", r)
+		}
+		i += size
+	}
+	if _, err := c.w.Write(b); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// flush reports an incomplete UTF-8 sequence left by the last Write.
+func (c *charsetWriter) flush() error {
+	if len(c.partial) > 0 {
+		c.partial = nil
+		return fmt.Errorf("xml: output ends with an incomplete UTF-8 sequence")
+	}
+	return nil
+}
+
+// outputEncoder returns the RuneEncoder of the charset of the output
+// of w, if w is a printer converting its output.
+func outputEncoder(w io.Writer) RuneEncoder {
+	if p, ok := w.(*printer); ok && p.charset != nil {
+		return p.charset.enc
+	}
+	return nil
+}
+
+// appendCharRef appends the numeric character reference for r to b.
+func appendCharRef(b []byte, r rune) []byte {
+	b = append(b, "&#x"...)
+	b = strconv.AppendInt(b, int64(r), 16)
+	return append(b, ';')
+}
+
+// closeStart completes the start tag held back by writeStart.
+func (p *printer) closeStart() {
+	p.openStart = false
+	p.Writer.WriteByte('>')
 }
 
 // createAttrPrefix finds the name space prefix attribute to use for the given name space,
@@ -330,13 +544,14 @@ func (p *printer) createAttrPrefix(url string) string {
 	if url == xmlURL {
 		return xmlPrefix
 	}
-
-	// Need to define a new name space.
-	if p.attrPrefix == nil {
-		p.attrPrefix = make(map[string]string)
-		p.attrNS = make(map[string]string)
+	if url == xsiURL && p.attrNS[xsiPrefix] == "" {
+		// Use the conventional prefix for the schema instance name space.
+		p.declarePrefix(xsiPrefix, url)
+		p.WriteByte(' ')
+		return xsiPrefix
 	}
 
+	// Need to define a new name space.
 	// Pick a name. We try to use the final element of the path
 	// but fall back to _.
 	prefix := strings.TrimRight(url, "/")
@@ -360,6 +575,18 @@ func (p *printer) createAttrPrefix(url string) string {
 		}
 	}
 
+	p.declarePrefix(prefix, url)
+	p.WriteByte(' ')
+	return prefix
+}
+
+// declarePrefix binds prefix to the name space url, writing its
+// declaration into the start tag being written.
+func (p *printer) declarePrefix(prefix, url string) {
+	if p.attrPrefix == nil {
+		p.attrPrefix = make(map[string]string)
+		p.attrNS = make(map[string]string)
+	}
 	p.attrPrefix[url] = prefix
 	p.attrNS[prefix] = url
 
@@ -367,11 +594,9 @@ func (p *printer) createAttrPrefix(url string) string {
 	p.WriteString(prefix)
 	p.WriteString(`="`)
 	EscapeText(p, []byte(url))
-	p.WriteString(`" `)
+	p.WriteByte('"')
 
 	p.prefixes = append(p.prefixes, prefix)
-
-	return prefix
 }
 
 // deleteAttrPrefix removes an attribute name space prefix.
@@ -408,26 +633,54 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, startTemplat
 		return fmt.Errorf("xml: EncodeElement of StartElement with missing name")
 	}
 
+	if finfo != nil && finfo.hasFixed {
+		return p.marshalFixed(finfo, val, startTemplate)
+	}
 	if !val.IsValid() {
 		return nil
 	}
 	if finfo != nil && finfo.flags&fOmitEmpty != 0 && isEmptyValue(val) {
 		return nil
 	}
+	if finfo != nil && p.isDefault(finfo, val) {
+		return nil
+	}
+	if finfo != nil {
+		name := finfo.name
+		if startTemplate != nil {
+			name = startTemplate.Name.Local
+		}
+		if err := p.checkField(finfo, val, name); err != nil {
+			return err
+		}
+	}
 
 	// Drill into interfaces and pointers.
 	// This can turn into an infinite loop given a cyclic chain,
 	// but it matches the Go 1 behavior.
+	fromInterface := false
 	for val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr {
 		if val.IsNil() {
+			if finfo != nil && finfo.flags&fNillable != 0 {
+				return p.marshalNil(finfo, startTemplate)
+			}
 			return nil
 		}
+		fromInterface = fromInterface || val.Kind() == reflect.Interface
 		val = val.Elem()
 	}
 
 	kind := val.Kind()
 	typ := val.Type()
 
+	if cv, ok := lookupCodec(p.encoder.codecs, val, true); ok {
+		return p.marshalTextInterface(cv, defaultStart(typ, finfo, startTemplate))
+	}
+
+	if finfo != nil && finfo.timeFormat != "" && typ == timeType {
+		return p.marshalValue(reflect.ValueOf(finfo.formatTime(val.Interface().(time.Time))), finfo, startTemplate)
+	}
+
 	// Check for marshaler.
 	if val.CanInterface() && typ.Implements(marshalerType) {
 		return p.marshalInterface(val.Interface().(Marshaler), defaultStart(typ, finfo, startTemplate))
@@ -450,6 +703,11 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, startTemplat
 		}
 	}
 
+	// Maps of entries keyed by name or attribute have no enclosing tag either.
+	if kind == reflect.Map && finfo != nil && (finfo.mapKey != "" || finfo.flags&fAny != 0) {
+		return p.marshalMapEntries(val, finfo)
+	}
+
 	// Slices and arrays iterate over the elements. They do not have an enclosing tag.
 	if (kind == reflect.Slice || kind == reflect.Array) && typ.Elem().Kind() != reflect.Uint8 {
 		for i, n := 0, val.Len(); i < n; i++ {
@@ -490,6 +748,9 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, startTemplat
 	if start.Name.Local == "" && finfo != nil {
 		start.Name.Space, start.Name.Local = finfo.xmlns, finfo.name
 	}
+	if startTemplate == nil && finfo != nil {
+		finfo.addPredicateAttr(&start)
+	}
 	if start.Name.Local == "" {
 		name := typ.Name()
 		if name == "" {
@@ -498,27 +759,37 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, startTemplat
 		start.Name.Local = name
 	}
 
+	if kind == reflect.Struct {
+		if err := p.beforeMarshal(val, start.Name.Local); err != nil {
+			return err
+		}
+		if tinfo.base != nil {
+			if bv := tinfo.base.value(val); !bv.IsNil() {
+				return p.marshalValue(bv, nil, &start)
+			}
+		}
+	}
+
 	// Attributes
 	for i := range tinfo.fields {
 		finfo := &tinfo.fields[i]
-		if finfo.flags&fAttr == 0 {
+		if finfo.flags&fAttr == 0 || len(finfo.parents) > 0 {
 			continue
 		}
-		fv := finfo.value(val)
-
-		if finfo.flags&fOmitEmpty != 0 && isEmptyValue(fv) {
-			continue
-		}
-
-		if fv.Kind() == reflect.Interface && fv.IsNil() {
-			continue
+		if err := p.marshalFieldAttr(&start, finfo, finfo.value(val)); err != nil {
+			return err
 		}
+	}
 
-		name := xml.Name{Space: finfo.xmlns, Local: finfo.name}
-		if err := p.marshalAttr(&start, name, fv); err != nil {
-			return err
+	if fromInterface {
+		if name, ok := p.xsiTypeName(typ); ok && !hasXSIType(start.Attr) {
+			p.xsiType = &name
 		}
 	}
+	if len(p.tags) == 0 && needsXSI(typ) {
+		// Declare the prefix once for all nillable fields below.
+		p.declareXSI = true
+	}
 
 	if err := p.writeStart(&start); err != nil {
 		return err
@@ -526,6 +797,8 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, startTemplat
 
 	if val.Kind() == reflect.Struct {
 		err = p.marshalStruct(tinfo, val)
+	} else if val.Kind() == reflect.Map {
+		err = p.marshalMapEntries(val, nil)
 	} else {
 		s, b, err1 := p.marshalSimple(typ, val)
 		if err1 != nil {
@@ -547,8 +820,92 @@ func (p *printer) marshalValue(val reflect.Value, finfo *fieldInfo, startTemplat
 	return p.cachedWriteError()
 }
 
+// marshalFieldAttr adds the attribute for fv, the value of the
+// attribute field finfo, to start.
+func (p *printer) marshalFieldAttr(start *xml.StartElement, finfo *fieldInfo, fv reflect.Value) error {
+	if finfo.hasFixed {
+		if err := p.checkField(finfo, fv, start.Name.Local, "@"+finfo.name); err != nil {
+			return err
+		}
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Space: finfo.xmlns, Local: finfo.name}, Value: finfo.fixed})
+		return nil
+	}
+
+	if p.omitAttr(finfo, fv) {
+		return nil
+	}
+
+	if err := p.checkField(finfo, fv, start.Name.Local, "@"+finfo.name); err != nil {
+		return err
+	}
+
+	if finfo.flags&fAny != 0 && isStringMap(fv.Type()) {
+		if fv = indirect(fv); fv.Kind() == reflect.Map {
+			if err := p.marshalMapAttrs(start, fv); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if finfo.timeFormat != "" {
+		if t, ok := indirect(fv).Interface().(time.Time); ok {
+			fv = reflect.ValueOf(finfo.formatTime(t))
+		}
+	}
+
+	name := xml.Name{Space: finfo.xmlns, Local: finfo.name}
+	return p.marshalAttr(start, name, fv)
+}
+
+// omitAttr reports whether fv, the value of the attribute field finfo,
+// produces no attribute.
+func (p *printer) omitAttr(finfo *fieldInfo, fv reflect.Value) bool {
+	if finfo.hasFixed {
+		return false
+	}
+	if finfo.flags&fOmitEmpty != 0 && isEmptyValue(fv) || p.isDefault(finfo, fv) {
+		return true
+	}
+	return fv.Kind() == reflect.Interface && fv.IsNil()
+}
+
+// isDefault reports whether the encoder omits defaults and val, the
+// value of the field finfo, encodes to the default value of the field.
+func (p *printer) isDefault(finfo *fieldInfo, val reflect.Value) bool {
+	if !finfo.hasDefault || finfo.hasFixed || !p.encoder.OmitDefaults {
+		return false
+	}
+	text, ok := p.fieldText(finfo, val)
+	return ok && text == finfo.defValue
+}
+
+// fieldText returns the text val, the value of the field finfo, has as
+// an attribute, if it has one.
+func (p *printer) fieldText(finfo *fieldInfo, val reflect.Value) (string, bool) {
+	if finfo.timeFormat != "" {
+		if t, ok := indirect(val).Interface().(time.Time); ok {
+			return finfo.formatTime(t), true
+		}
+	}
+	var start xml.StartElement
+	if err := p.marshalAttr(&start, xml.Name{Local: finfo.name}, val); err != nil || len(start.Attr) != 1 {
+		return "", false
+	}
+	return start.Attr[0].Value, true
+}
+
 // marshalAttr marshals an attribute with the given name and value, adding to start.Attr.
 func (p *printer) marshalAttr(start *xml.StartElement, name xml.Name, val reflect.Value) error {
+	if cv, ok := lookupCodec(p.encoder.codecs, indirect(val), true); ok {
+		text, err := cv.MarshalText()
+		if err != nil {
+			return err
+		}
+		start.Attr = append(start.Attr, xml.Attr{Name: name, Value: string(text)})
+		return nil
+	}
+
 	if val.CanInterface() && val.Type().Implements(marshalerAttrType) {
 		attr, err := val.Interface().(MarshalerAttr).MarshalXMLAttr(name)
 		if err != nil {
@@ -579,7 +936,7 @@ func (p *printer) marshalAttr(start *xml.StartElement, name xml.Name, val reflec
 		if err != nil {
 			return err
 		}
-		start.Attr = append(start.Attr, xml.Attr{name, string(text)})
+		start.Attr = append(start.Attr, xml.Attr{Name: name, Value: string(text)})
 		return nil
 	}
 
@@ -590,7 +947,7 @@ func (p *printer) marshalAttr(start *xml.StartElement, name xml.Name, val reflec
 			if err != nil {
 				return err
 			}
-			start.Attr = append(start.Attr, xml.Attr{name, string(text)})
+			start.Attr = append(start.Attr, xml.Attr{Name: name, Value: string(text)})
 			return nil
 		}
 	}
@@ -643,6 +1000,7 @@ func defaultStart(typ reflect.Type, finfo *fieldInfo, startTemplate *xml.StartEl
 	} else if finfo != nil && finfo.name != "" {
 		start.Name.Local = finfo.name
 		start.Name.Space = finfo.xmlns
+		finfo.addPredicateAttr(&start)
 	} else if typ.Name() != "" {
 		start.Name.Local = typ.Name()
 	} else {
@@ -705,6 +1063,18 @@ func (p *printer) writeStart(start *xml.StartElement) error {
 		p.WriteByte('"')
 	}
 
+	if p.declareXSI {
+		p.declareXSI = false
+		if p.attrPrefix[xsiURL] == "" && p.attrNS[xsiPrefix] == "" {
+			p.WriteByte(' ')
+			p.declarePrefix(xsiPrefix, xsiURL)
+		}
+	}
+	if p.xsiType != nil {
+		p.writeXSIType(*p.xsiType)
+		p.xsiType = nil
+	}
+
 	// Attributes
 	for _, attr := range start.Attr {
 		name := attr.Name
@@ -721,6 +1091,12 @@ func (p *printer) writeStart(start *xml.StartElement) error {
 		p.EscapeString(attr.Value)
 		p.WriteByte('"')
 	}
+	if p.encoder.SelfClosing {
+		// Leave the tag open until we know whether
+		// the element has any content.
+		p.openStart = true
+		return nil
+	}
 	p.WriteByte('>')
 	return nil
 }
@@ -740,6 +1116,15 @@ func (p *printer) writeEnd(name xml.Name) error {
 	}
 	p.tags = p.tags[:len(p.tags)-1]
 
+	if p.openStart {
+		// Nothing was written since the start tag.
+		p.openStart = false
+		p.writeIndent(-1)
+		p.Writer.WriteString("/>")
+		p.popPrefix()
+		return nil
+	}
+
 	p.writeIndent(-1)
 	p.WriteByte('<')
 	p.WriteByte('/')
@@ -801,10 +1186,28 @@ func indirect(vf reflect.Value) reflect.Value {
 }
 
 func (p *printer) marshalStruct(tinfo *typeInfo, val reflect.Value) error {
-	s := parentStack{p: p}
+	s := parentStack{p: p, tinfo: tinfo, val: val}
 	for i := range tinfo.fields {
 		finfo := &tinfo.fields[i]
 		if finfo.flags&fAttr != 0 {
+			if len(finfo.parents) == 0 {
+				continue
+			}
+			// Attributes of nested elements are written as their
+			// parents are first pushed. Open the parents if no
+			// other field has.
+			if s.written != nil && s.written[i] {
+				continue
+			}
+			if err := s.trim(finfo.parents); err != nil {
+				return err
+			}
+			vf := finfo.value(val)
+			if len(finfo.parents) > len(s.stack) && !p.omitAttr(finfo, vf) && (vf.Kind() != reflect.Ptr || !vf.IsNil()) {
+				if err := s.push(finfo.parents[len(s.stack):]); err != nil {
+					return err
+				}
+			}
 			continue
 		}
 		vf := finfo.value(val)
@@ -815,9 +1218,27 @@ func (p *printer) marshalStruct(tinfo *typeInfo, val reflect.Value) error {
 			if finfo.flags&fMode == fCDATA {
 				emit = emitCDATA
 			}
-			if err := s.trim(finfo.parents); err != nil {
+			if err := s.open(finfo.parents); err != nil {
 				return err
 			}
+			if cv, ok := lookupCodec(p.encoder.codecs, indirect(vf), true); ok {
+				data, err := cv.MarshalText()
+				if err != nil {
+					return err
+				}
+				if err := emit(p, data); err != nil {
+					return err
+				}
+				continue
+			}
+			if finfo.timeFormat != "" {
+				if t, ok := indirect(vf).Interface().(time.Time); ok {
+					if err := emit(p, []byte(finfo.formatTime(t))); err != nil {
+						return err
+					}
+					continue
+				}
+			}
 			if vf.CanInterface() && vf.Type().Implements(textMarshalerType) {
 				data, err := vf.Interface().(encoding.TextMarshaler).MarshalText()
 				if err != nil {
@@ -919,6 +1340,11 @@ func (p *printer) marshalStruct(tinfo *typeInfo, val reflect.Value) error {
 			continue
 
 		case fInnerXml:
+			if len(finfo.parents) > 0 {
+				if err := s.open(finfo.parents); err != nil {
+					return err
+				}
+			}
 			vf = indirect(vf)
 			iface := vf.Interface()
 			switch raw := iface.(type) {
@@ -935,7 +1361,7 @@ func (p *printer) marshalStruct(tinfo *typeInfo, val reflect.Value) error {
 				return err
 			}
 			if len(finfo.parents) > len(s.stack) {
-				if vf.Kind() != reflect.Ptr && vf.Kind() != reflect.Interface || !vf.IsNil() {
+				if finfo.hasFixed || (vf.Kind() != reflect.Ptr && vf.Kind() != reflect.Interface || !vf.IsNil()) && !p.isDefault(finfo, vf) {
 					if err := s.push(finfo.parents[len(s.stack):]); err != nil {
 						return err
 					}
@@ -988,8 +1414,11 @@ func (p *printer) writeIndent(depthDelta int) {
 }
 
 type parentStack struct {
-	p     *printer
-	stack []string
+	p       *printer
+	tinfo   *typeInfo
+	val     reflect.Value
+	stack   []string
+	written []bool // attribute fields of nested elements already written
 }
 
 // trim updates the XML context to match the longest common prefix of the stack
@@ -1003,7 +1432,8 @@ func (s *parentStack) trim(parents []string) error {
 		}
 	}
 	for i := len(s.stack) - 1; i >= split; i-- {
-		if err := s.p.writeEnd(xml.Name{Local: s.stack[i]}); err != nil {
+		name, _ := splitPredicate(s.stack[i])
+		if err := s.p.writeEnd(xml.Name{Local: name}); err != nil {
 			return err
 		}
 	}
@@ -1011,17 +1441,65 @@ func (s *parentStack) trim(parents []string) error {
 	return nil
 }
 
-// push adds parent elements to the stack and writes open tags.
+// open updates the XML context to match the given parents, closing
+// the elements off their path and opening the missing ones.
+func (s *parentStack) open(parents []string) error {
+	if err := s.trim(parents); err != nil {
+		return err
+	}
+	if len(parents) > len(s.stack) {
+		return s.push(parents[len(s.stack):])
+	}
+	return nil
+}
+
+// push adds parent elements to the stack and writes open tags,
+// with the attributes of the fields on their paths.
 func (s *parentStack) push(parents []string) error {
 	for i := 0; i < len(parents); i++ {
-		if err := s.p.writeStart(&xml.StartElement{Name: xml.Name{Local: parents[i]}}); err != nil {
+		name, src := splitPredicate(parents[i])
+		start := xml.StartElement{Name: xml.Name{Local: name}}
+		if src != "" {
+			if pred, _ := parsePredicate(src); pred != nil {
+				if attr, ok := pred.attrFor(); ok {
+					start.Attr = append(start.Attr, attr)
+				}
+			}
+		}
+		s.stack = append(s.stack, parents[i])
+		for j := range s.tinfo.fields {
+			finfo := &s.tinfo.fields[j]
+			if finfo.flags&fAttr == 0 || !equalPath(finfo.parents, s.stack) || s.written != nil && s.written[j] {
+				continue
+			}
+			if err := s.p.marshalFieldAttr(&start, finfo, finfo.value(s.val)); err != nil {
+				return err
+			}
+			if s.written == nil {
+				s.written = make([]bool, len(s.tinfo.fields))
+			}
+			s.written[j] = true
+		}
+		if err := s.p.writeStart(&start); err != nil {
 			return err
 		}
 	}
-	s.stack = append(s.stack, parents...)
 	return nil
 }
 
+// equalPath reports whether the paths a and b are the same.
+func equalPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func isEmptyValue(v reflect.Value) bool {
 	switch v.Kind() {
 	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
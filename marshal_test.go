@@ -1491,7 +1491,7 @@ var marshalTests = []struct {
 	},
 	{
 		ExpectXML:     `<IfaceElement><T1></T1><Element>hi</Element><T2></T2></IfaceElement>`,
-		Value:         &IfaceElement{Element: nil},
+		Value:         &IfaceElement{Element: &Node{XMLName: xml.Name{Local: "Element"}, Content: "hi"}},
 		UnmarshalOnly: true,
 	},
 	{
@@ -1537,7 +1537,7 @@ var marshalTests = []struct {
 	},
 	{
 		ExpectXML:     `<IfaceOmitEmpty><T1></T1><OmitEmpty>hi</OmitEmpty><T2></T2></IfaceOmitEmpty>`,
-		Value:         &IfaceOmitEmpty{OmitEmpty: nil},
+		Value:         &IfaceOmitEmpty{OmitEmpty: &Node{XMLName: xml.Name{Local: "OmitEmpty"}, Content: "hi"}},
 		UnmarshalOnly: true,
 	},
 	{
@@ -1576,7 +1576,7 @@ var marshalTests = []struct {
 	},
 	{
 		ExpectXML:     `<IfaceAny><T1></T1><Any>hi</Any><T2></T2></IfaceAny>`,
-		Value:         &IfaceAny{Any: nil},
+		Value:         &IfaceAny{Any: &Node{XMLName: xml.Name{Local: "Any"}, Content: "hi"}},
 		UnmarshalOnly: true,
 	},
 	{
@@ -1613,7 +1613,7 @@ var marshalTests = []struct {
 	},
 	{
 		ExpectXML:     `<IfaceFoo><T1></T1><Foo>hi</Foo><T2></T2></IfaceFoo>`,
-		Value:         &IfaceAny{Any: nil},
+		Value:         &IfaceAny{Any: &Node{XMLName: xml.Name{Local: "Foo"}, Content: "hi"}},
 		UnmarshalOnly: true,
 	},
 	{
@@ -1636,6 +1636,12 @@ var marshalTests = []struct {
 		Value:         &DirectAny{Any: string("")},
 		UnmarshalOnly: true,
 	},
+
+	// Attributes of nested elements
+	{
+		ExpectXML: `<AttrParent><X Y="1"></X></AttrParent>`,
+		Value:     &AttrParent{X: "1"},
+	},
 }
 
 func TestMarshal(t *testing.T) {
@@ -1706,11 +1712,6 @@ var marshalErrorTests = []struct {
 		Value: &Domain{Comment: []byte("f--bar")},
 		Err:   `xml: comments must not contain "--"`,
 	},
-	// Reject parent chain with attr, never worked; see golang.org/issue/5033.
-	{
-		Value: &AttrParent{},
-		Err:   `xml: X>Y chain not valid with attr flag`,
-	},
 	{
 		Value: BadAttr{map[string]string{"X": "Y"}},
 		Err:   `xml: unsupported type: map[string]string`,
@@ -2451,3 +2452,335 @@ func TestInvalidXMLName(t *testing.T) {
 		t.Errorf("error %q does not contain %q", err, want)
 	}
 }
+
+type SelfClosingPort struct {
+	XMLName struct{} `xml:"port"`
+	Type    string   `xml:"type,attr,omitempty"`
+	Number  string   `xml:",chardata"`
+	Note    *string  `xml:"note"`
+}
+
+func TestEncodeSelfClosing(t *testing.T) {
+	note := ""
+	tests := []struct {
+		v      interface{}
+		indent bool
+		want   string
+	}{
+		{v: &SelfClosingPort{Type: "ssl"}, want: `<port type="ssl"/>`},
+		{v: &SelfClosingPort{Number: "443"}, want: `<port>443</port>`},
+		{v: &SelfClosingPort{Note: &note}, want: `<port><note/></port>`},
+		{v: &SelfClosingPort{Note: &note}, indent: true, want: "<port>\n  <note/>\n</port>"},
+		{v: &Domain{Comment: []byte("x")}, want: `<domain><!--x--></domain>`},
+		{v: &Domain{}, want: `<domain/>`},
+	}
+	for i, tt := range tests {
+		var b bytes.Buffer
+		enc := NewEncoder(&b)
+		enc.SelfClosing = true
+		if tt.indent {
+			enc.Indent("", "  ")
+		}
+		if err := enc.Encode(tt.v); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if have := b.String(); have != tt.want {
+			t.Errorf("#%d: have %q, want %q", i, have, tt.want)
+		}
+	}
+
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.SelfClosing = true
+	if err := enc.EncodeElement("", xml.StartElement{Name: xml.Name{Local: "empty"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tok := range []xml.Token{
+		xml.StartElement{Name: xml.Name{Local: "a"}},
+		xml.StartElement{Name: xml.Name{Local: "b"}, Attr: []xml.Attr{{Name: xml.Name{Local: "k"}, Value: "v"}}},
+		xml.CharData(""),
+		xml.EndElement{Name: xml.Name{Local: "b"}},
+		xml.StartElement{Name: xml.Name{Local: "c"}},
+		xml.CharData("text"),
+		xml.EndElement{Name: xml.Name{Local: "c"}},
+		xml.EndElement{Name: xml.Name{Local: "a"}},
+	} {
+		if err := enc.EncodeToken(tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := b.String(), `<empty/><a><b k="v"/><c>text</c></a>`; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestEncodeDeclaration(t *testing.T) {
+	tests := []struct {
+		decl   *Declaration
+		tokens []xml.Token
+		want   string
+	}{{
+		decl:   &Declaration{},
+		tokens: []xml.Token{xml.StartElement{Name: xml.Name{Local: "a"}}, xml.EndElement{Name: xml.Name{Local: "a"}}},
+		want:   "<?xml version=\"1.0\"?>\n<a></a>",
+	}, {
+		decl:   &Declaration{Version: "1.0", Encoding: "UTF-8", Standalone: "yes"},
+		tokens: []xml.Token{xml.StartElement{Name: xml.Name{Local: "a"}}, xml.EndElement{Name: xml.Name{Local: "a"}}},
+		want:   "<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"yes\"?>\n<a></a>",
+	}, {
+		decl:   &Declaration{Encoding: "UTF-8"},
+		tokens: []xml.Token{xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0"`)}, xml.StartElement{Name: xml.Name{Local: "a"}}, xml.EndElement{Name: xml.Name{Local: "a"}}},
+		want:   `<?xml version="1.0"?><a></a>`,
+	}}
+	for i, tt := range tests {
+		var b bytes.Buffer
+		enc := NewEncoder(&b)
+		enc.Declaration = tt.decl
+		for _, tok := range tt.tokens {
+			if err := enc.EncodeToken(tok); err != nil {
+				t.Fatalf("#%d: EncodeToken(%#v): %v", i, tok, err)
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatalf("#%d: Flush: %v", i, err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("#%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.Declaration = &Declaration{Encoding: "UTF-8"}
+	enc.Indent("", "  ")
+	if err := enc.Encode(&Port{Number: "443"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<port>443</port>"
+	if got := b.String(); got != want {
+		t.Errorf("Encode: got %q, want %q", got, want)
+	}
+
+	for _, decl := range []Declaration{
+		{Version: `1.0"?><x`},
+		{Version: "2.0"},
+		{Encoding: `UTF-8?>`},
+		{Encoding: "8bit"},
+		{Standalone: `yes'`},
+	} {
+		b.Reset()
+		enc := NewEncoder(&b)
+		enc.Declaration = &decl
+		if err := enc.Encode(&Port{Number: "443"}); err == nil {
+			t.Errorf("Encode with %+v: want error, wrote %q", decl, b.String())
+		}
+	}
+}
+
+// latin1Writer converts UTF-8 into ISO-8859-1.
+type latin1Writer struct {
+	w io.Writer
+}
+
+func (l latin1Writer) CanEncode(r rune) bool { return r < 0x100 }
+
+func (l latin1Writer) Write(p []byte) (int, error) {
+	var b []byte
+	for _, r := range string(p) {
+		b = append(b, byte(r))
+	}
+	if _, err := l.w.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func TestEncodeCharsetWriter(t *testing.T) {
+	var charset string
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.Declaration = &Declaration{Encoding: "ISO-8859-1"}
+	enc.CharsetWriter = func(cs string, w io.Writer) (io.Writer, error) {
+		charset = cs
+		return latin1Writer{w}, nil
+	}
+	if err := enc.EncodeElement("café Привет", xml.StartElement{Name: xml.Name{Local: "s"}}); err != nil {
+		t.Fatal(err)
+	}
+	if charset != "ISO-8859-1" {
+		t.Errorf("CharsetWriter called with %q, want %q", charset, "ISO-8859-1")
+	}
+	want := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<s>caf\xe9 &#x41f;&#x440;&#x438;&#x432;&#x435;&#x442;</s>"
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	enc = NewEncoder(new(bytes.Buffer))
+	err := enc.EncodeToken(xml.ProcInst{Target: "xml", Inst: []byte(`version="1.0" encoding="windows-1251"`)})
+	if err == nil || !strings.Contains(err.Error(), "windows-1251") {
+		t.Errorf("EncodeToken without CharsetWriter: got error %v, want error naming the charset", err)
+	}
+
+	// A rune split across writes must be kept whole.
+	var out bytes.Buffer
+	w := &charsetWriter{w: latin1Writer{&out}, enc: latin1Writer{}}
+	s := []byte("éü")
+	for i := range s {
+		if _, err := w.Write(s[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := out.String(), "\xe9\xfc"; got != want {
+		t.Errorf("split writes: got %q, want %q", got, want)
+	}
+	if _, err := w.Write([]byte("\xe2\x82")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.flush(); err == nil {
+		t.Errorf("incomplete sequence at flush: want error")
+	}
+
+	// Character references are only written where they are allowed.
+	newEncoder := func(b *bytes.Buffer) *Encoder {
+		enc := NewEncoder(b)
+		enc.Declaration = &Declaration{Encoding: "ISO-8859-1"}
+		enc.CharsetWriter = func(cs string, w io.Writer) (io.Writer, error) {
+			return latin1Writer{w}, nil
+		}
+		return enc
+	}
+	b.Reset()
+	enc = newEncoder(&b)
+	start := xml.StartElement{Name: xml.Name{Local: "s"}, Attr: []xml.Attr{{Name: xml.Name{Local: "a"}, Value: "€"}}}
+	if err := enc.EncodeElement("", start); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n<s a=\"&#x20ac;\"></s>"; got != want {
+		t.Errorf("attribute: got %q, want %q", got, want)
+	}
+	for _, tok := range []xml.Token{
+		xml.StartElement{Name: xml.Name{Local: "имя"}},
+		xml.Comment("€"),
+		xml.ProcInst{Target: "pi", Inst: []byte("€")},
+	} {
+		enc = newEncoder(new(bytes.Buffer))
+		err := enc.EncodeToken(tok)
+		if err == nil {
+			err = enc.Flush()
+		}
+		if err == nil || !strings.Contains(err.Error(), "cannot be encoded") {
+			t.Errorf("EncodeToken(%#v): got error %v", tok, err)
+		}
+	}
+}
+
+func TestMarshalTimeFormat(t *testing.T) {
+	ts := time.Date(2021, 6, 5, 4, 8, 9, 123000000, time.UTC)
+	v := TimeFormats{
+		Date:    ts,
+		Local:   ts,
+		Epoch:   &ts,
+		Millis:  []time.Time{ts},
+		Offset:  ts,
+		Default: ts,
+		Text:    ts,
+	}
+	if _, err := time.LoadLocation("Europe/Moscow"); err != nil {
+		t.Skip(err)
+	}
+	got, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<TimeFormats date="2021-06-05">` +
+		`<local>05.06.2021T07:08</local>` +
+		`<epoch>1622866089</epoch>` +
+		`<ms>1622866089123</ms>` +
+		`<offset>2021-06-05T07:08:09+03:00</offset>` +
+		`<default>2021-06-05T04:08:09.123Z</default>` +
+		`04:08</TimeFormats>`
+	if string(got) != want {
+		t.Errorf("Marshal:\nhave %s\nwant %s", got, want)
+	}
+
+	v.Epoch = nil
+	if got, err = Marshal(&v); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(got), "<epoch>") {
+		t.Errorf("Marshal with nil time wrote %s", got)
+	}
+}
+
+func TestMarshalOmitDefaults(t *testing.T) {
+	v := DefaultPrice{
+		Currency: "RUB",
+		Amount:   2,
+		Qty:      1,
+		Date:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Note:     "none",
+	}
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.OmitDefaults = true
+	if err := enc.Encode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), `<price><amount>2</amount></price>`; got != want {
+		t.Errorf("OmitDefaults:\nhave %s\nwant %s", got, want)
+	}
+
+	out, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<price currency="RUB" qty="1" date="2020-01-01"><amount>2</amount><info><note>none</note></info></price>`
+	if string(out) != want {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, want)
+	}
+}
+
+type TagKeyItem struct {
+	XMLName struct{} `xml:"item"`
+	ID      string   `xml:"id" xmlutils:"id,attr"`
+	Name    string   `xml:"name"`
+	Kind    string   `xml:"kind" ext:"type,attr"`
+}
+
+func TestTagKey(t *testing.T) {
+	v := TagKeyItem{ID: "1", Name: "pen", Kind: "office"}
+	const data = `<item id="1"><name>pen</name><kind>office</kind></item>`
+	out, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, data)
+	}
+	var got TagKeyItem
+	if err := Unmarshal([]byte(data), &got); err != nil || got != v {
+		t.Errorf("Unmarshal = %+v, %v, want %+v", got, err, v)
+	}
+
+	defer SetTagKey("xmlutils")
+	SetTagKey("ext")
+	out, err = Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<item type="office"><id>1</id><name>pen</name></item>`; string(out) != want {
+		t.Errorf("Marshal with tag key ext:\nhave %s\nwant %s", out, want)
+	}
+
+	SetTagKey("")
+	out, err = Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<item><id>1</id><name>pen</name><kind>office</kind></item>`; string(out) != want {
+		t.Errorf("Marshal with xml tags only:\nhave %s\nwant %s", out, want)
+	}
+}
@@ -0,0 +1,57 @@
+package xmlutils
+
+import (
+	"strings"
+)
+
+// StripSeparators returns name without its '_', '-' and '.' characters.
+// It is meant for Decoder.NormalizeName, to match names such as
+// order_id, order-id and orderid with each other.
+func StripSeparators(name string) string {
+	if !strings.ContainsAny(name, "_-.") {
+		return name
+	}
+	var b strings.Builder
+	b.Grow(len(name))
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '_', '-', '.':
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// nameMatches reports whether name, read from the input, matches the
+// name want from a struct tag, after NormalizeName and with regard to
+// CaseInsensitive.
+func (d *Decoder) nameMatches(want, name string) bool {
+	if want == name {
+		return true
+	}
+	if d.NormalizeName != nil {
+		want, name = d.NormalizeName(want), d.NormalizeName(name)
+	}
+	if d.CaseInsensitive {
+		return strings.EqualFold(want, name)
+	}
+	return want == name
+}
+
+// fieldMatches reports whether name matches the name of finfo or one
+// of its aliases.
+func (d *Decoder) fieldMatches(finfo *fieldInfo, name string) bool {
+	if d.NormalizeName == nil && !d.CaseInsensitive {
+		return finfo.hasName(name)
+	}
+	if d.nameMatches(finfo.name, name) {
+		return true
+	}
+	for _, alias := range finfo.aliases {
+		if d.nameMatches(alias, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package xmlutils
+
+import (
+	"strings"
+	"testing"
+)
+
+type MatchOrder struct {
+	XMLName struct{} `xml:"Order"`
+	OrderID string   `xml:"OrderID"`
+	Status  string   `xml:"status,attr"`
+	Total   int      `xml:"Summary>total_sum"`
+}
+
+var matchTests = []struct {
+	input           string
+	caseInsensitive bool
+	normalize       func(string) string
+}{
+	{`<order STATUS="paid"><OrderId>7</OrderId><summary><Total_Sum>5</Total_Sum></summary></order>`, true, nil},
+	{`<Order status="paid"><Order-ID>7</Order-ID><Summary><total-sum>5</total-sum></Summary></Order>`, false, StripSeparators},
+	{`<order Status="paid"><order_id>7</order_id><SUMMARY><TotalSum>5</TotalSum></SUMMARY></order>`, true, StripSeparators},
+}
+
+func TestDecodeNameMatching(t *testing.T) {
+	want := MatchOrder{OrderID: "7", Status: "paid", Total: 5}
+	for i, tt := range matchTests {
+		var v MatchOrder
+		if err := Unmarshal([]byte(tt.input), &v); err == nil && v == want {
+			t.Errorf("#%d: matched without options", i)
+		}
+
+		v = MatchOrder{}
+		d := NewDecoder(strings.NewReader(tt.input))
+		d.CaseInsensitive = tt.caseInsensitive
+		d.NormalizeName = tt.normalize
+		if err := d.Decode(&v); err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if v != want {
+			t.Errorf("#%d: have %+v, want %+v", i, v, want)
+		}
+	}
+}
+
+func TestStripSeparators(t *testing.T) {
+	for in, want := range map[string]string{
+		"order_id":  "orderid",
+		"order-id":  "orderid",
+		"order.id":  "orderid",
+		"OrderID":   "OrderID",
+		"_a-b_c.d_": "abcd",
+	} {
+		if got := StripSeparators(in); got != want {
+			t.Errorf("StripSeparators(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"encoding"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
 	"strings"
@@ -149,7 +150,21 @@ func (d *Decoder) DecodeElement(v interface{}, start *xml.StartElement) error {
 	if val.Kind() != reflect.Ptr {
 		return errors.New("non-pointer passed to Unmarshal")
 	}
-	return d.unmarshal(val.Elem(), start)
+	if d.unmarshalDepth > 0 {
+		// Called from an UnmarshalXML method: problems are
+		// reported by the outermost DecodeElement.
+		return d.unmarshal(val.Elem(), start)
+	}
+	d.errs = nil
+	if err := d.unmarshal(val.Elem(), start); err != nil {
+		d.errs = nil
+		return err
+	}
+	if errs := d.errs; len(errs) > 0 {
+		d.errs = nil
+		return errs
+	}
+	return nil
 }
 
 // An UnmarshalError represents an error in the unmarshaling process.
@@ -157,6 +172,239 @@ type UnmarshalError string
 
 func (e UnmarshalError) Error() string { return string(e) }
 
+// A PathError records an error together with the path of the element
+// or attribute that caused it, such as "/order/item/price" or
+// "/order/item/@id". Pos is the start of the element and is only
+// known when Decoder.TrackPositions is set.
+type PathError struct {
+	Path string
+	Pos  Position
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	if e.Pos.Line == 0 {
+		return "xml: " + e.Path + ": " + e.Err.Error()
+	}
+	return fmt.Sprintf("xml: %s (line %d, column %d): %v", e.Path, e.Pos.Line, e.Pos.Column, e.Err)
+}
+
+func (e *PathError) Unwrap() error { return e.Err }
+
+// An ErrorList is returned by Decode when Decoder.CollectErrors is set
+// and one or more values could not be unmarshaled.
+type ErrorList []*PathError
+
+func (l ErrorList) Error() string {
+	s := make([]string, len(l))
+	for i, e := range l {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// A SourceMap maps Go field paths to the input ranges their values were
+// decoded from. See Decoder.SourceMap.
+type SourceMap map[string]SourceRange
+
+// fieldPath returns the Go path of the field being decoded, with names
+// appended to it.
+func (d *Decoder) fieldPath(names ...string) string {
+	var b strings.Builder
+	for _, list := range [][]string{d.fields, names} {
+		for _, name := range list {
+			if b.Len() > 0 && name[0] != '[' {
+				b.WriteByte('.')
+			}
+			b.WriteString(name)
+		}
+	}
+	return b.String()
+}
+
+// unmarshalField unmarshals start into the field of sv described by
+// finfo, recording its input range if SourceMap is set.
+func (d *Decoder) unmarshalField(finfo *fieldInfo, sv reflect.Value, start *xml.StartElement) error {
+	val := finfo.value(sv)
+	if d.SourceMap == nil {
+		return d.unmarshalFieldValue(finfo, val, start)
+	}
+	d.fields = append(d.fields, sv.Type().FieldByIndex(finfo.idx).Name)
+	begin := d.elementPos()
+	err := d.unmarshalFieldValue(finfo, val, start)
+	if err == nil && !isElementSlice(val.Type()) {
+		// Slice items are recorded one by one by unmarshal.
+		d.SourceMap[d.fieldPath()] = SourceRange{Start: begin, End: d.tokEnd}
+	}
+	d.fields = d.fields[:len(d.fields)-1]
+	return err
+}
+
+// unmarshalFieldValue reads the element start into the field value val,
+// applying the tag options of finfo.
+func (d *Decoder) unmarshalFieldValue(finfo *fieldInfo, val reflect.Value, start *xml.StartElement) error {
+	if finfo.timeFormat != "" || finfo.constrained() {
+		pos := d.elementPos()
+		var s string
+		if err := d.unmarshal(reflect.ValueOf(&s).Elem(), start); err != nil {
+			return err
+		}
+		ok, err := d.checkField(finfo, s, pos, start.Name.Local)
+		if err != nil || !ok || finfo.blank {
+			return err
+		}
+		attr := xml.Attr{Name: start.Name, Value: s}
+		return d.collect(d.unmarshalFieldAttr(finfo, val, attr), pos, start.Name.Local)
+	}
+	if (finfo.mapKey != "" || finfo.flags&fAny != 0) && isStringMap(val.Type()) {
+		return d.unmarshalMapEntry(finfo, val, start)
+	}
+	return d.unmarshal(val, start)
+}
+
+// unmarshalFieldAttr sets the field value val from attr, applying the
+// tag options of finfo.
+func (d *Decoder) unmarshalFieldAttr(finfo *fieldInfo, val reflect.Value, attr xml.Attr) error {
+	if finfo.timeFormat != "" {
+		return finfo.setTime(val, attr.Value)
+	}
+	if isStringMap(val.Type()) {
+		return d.unmarshalMapAttr(val, attr)
+	}
+	return d.unmarshalAttr(val, attr)
+}
+
+// setTime stores the time parsed from s in val, which holds a
+// time.Time, a pointer to one or a slice of them. Blank input
+// leaves val unchanged.
+func (finfo *fieldInfo) setTime(val reflect.Value, s string) error {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	t, err := finfo.parseTime(s)
+	if err != nil {
+		return err
+	}
+	switch val.Kind() {
+	case reflect.Ptr:
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		return finfo.setTime(val.Elem(), s)
+	case reflect.Slice:
+		n := val.Len()
+		val.Set(reflect.Append(val, reflect.Zero(val.Type().Elem())))
+		return finfo.setTime(val.Index(n), s)
+	}
+	val.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// setDefaults fills the fields of sv that have a default value but
+// were not present in the element start and hold their zero value.
+func (d *Decoder) setDefaults(tinfo *typeInfo, sv reflect.Value, seen []bool, start *xml.StartElement, pos Position) error {
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if !finfo.hasDefault || finfo.blank || seen[i] {
+			continue
+		}
+		fv := finfo.value(sv)
+		if !fv.IsZero() {
+			continue
+		}
+		names := append([]string{start.Name.Local}, finfo.parents...)
+		if finfo.flags&fAttr != 0 {
+			names = append(names, "@"+finfo.name)
+		} else {
+			names = append(names, finfo.name)
+		}
+		attr := xml.Attr{Name: xml.Name{Space: finfo.xmlns, Local: finfo.name}, Value: finfo.defValue}
+		if err := d.collect(d.unmarshalFieldAttr(finfo, fv, attr), pos, names...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isElementSlice reports whether values of typ receive one XML element
+// per slice item.
+func isElementSlice(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.Slice && typ.Elem().Kind() != reflect.Uint8
+}
+
+// An UnconsumedReport maps the paths of elements and attributes that
+// were not decoded into any value to the number of times they were
+// skipped. See Decoder.Unconsumed.
+type UnconsumedReport map[string]int
+
+// skipped records that the current element is being skipped.
+func (d *Decoder) skipped() {
+	if d.Unconsumed != nil {
+		d.Unconsumed[d.path()]++
+	}
+}
+
+// skippedAttr records that attribute a of the current element was not
+// decoded. Name space declarations are not reported.
+func (d *Decoder) skippedAttr(a xml.Attr) {
+	if d.Unconsumed == nil || a.Name.Space == xmlnsPrefix || a.Name.Space == "" && a.Name.Local == xmlnsPrefix || isXSIAttr(a.Name, "type") || isXSIAttr(a.Name, "nil") {
+		return
+	}
+	d.Unconsumed[d.path("@"+a.Name.Local)]++
+}
+
+// pathError annotates err with pos and the current path extended by names.
+func (d *Decoder) pathError(err error, pos Position, names ...string) *PathError {
+	return &PathError{Path: d.path(names...), Pos: pos, Err: err}
+}
+
+// collect records err for the value at the current path extended by
+// names and returns nil if CollectErrors is set. Otherwise it returns
+// err, annotated with that path and pos if TrackPositions is set.
+func (d *Decoder) collect(err error, pos Position, names ...string) error {
+	if err == nil {
+		return nil
+	}
+	if !d.CollectErrors {
+		if _, ok := err.(*PathError); d.TrackPositions && !ok {
+			return d.pathError(err, pos, names...)
+		}
+		return err
+	}
+	d.errs = append(d.errs, d.pathError(err, pos, names...))
+	return nil
+}
+
+// fail records err for the value at the current path extended by names
+// and returns nil if CollectErrors is set. Otherwise it returns err
+// annotated with that path.
+func (d *Decoder) fail(err error, pos Position, names ...string) error {
+	if d.CollectErrors {
+		d.errs = append(d.errs, d.pathError(err, pos, names...))
+		return nil
+	}
+	return d.pathError(err, pos, names...)
+}
+
+// reject is used for an element whose start has been read but whose
+// content has not. If CollectErrors is set, it records err and skips
+// the element; otherwise it returns err, annotated with its location
+// if TrackPositions is set.
+func (d *Decoder) reject(err error) error {
+	pos := d.elementPos()
+	if !d.CollectErrors {
+		if d.TrackPositions {
+			return d.pathError(err, pos)
+		}
+		return err
+	}
+	d.collect(err, pos)
+	return d.Skip()
+}
+
 // Unmarshaler is the interface implemented by objects that can unmarshal
 // an XML element description of themselves.
 //
@@ -203,9 +451,30 @@ func (d *Decoder) unmarshalInterface(val Unmarshaler, start *xml.StartElement) e
 	// Record that decoder must stop at end tag corresponding to start.
 	d.pushEOF()
 
+	var path string
+	var pos Position
+	if d.CollectErrors {
+		path, pos = d.path(), d.elementPos()
+	}
 	d.unmarshalDepth++
 	err := val.UnmarshalXML(d, *start)
 	d.unmarshalDepth--
+	if err != nil && d.CollectErrors && d.err == nil {
+		// The input is still readable: record the problem and
+		// discard whatever UnmarshalXML left of the element.
+		for {
+			_, terr := d.Token()
+			if terr == io.EOF {
+				d.errs = append(d.errs, &PathError{Path: path, Pos: pos, Err: err})
+				err = nil
+				break
+			}
+			if terr != nil {
+				err = terr
+				break
+			}
+		}
+	}
 	if err != nil {
 		d.popEOF()
 		return err
@@ -221,7 +490,8 @@ func (d *Decoder) unmarshalInterface(val Unmarshaler, start *xml.StartElement) e
 // unmarshalTextInterface unmarshals a single XML element into val.
 // The chardata contained in the element (but not its children)
 // is passed to the text unmarshaler.
-func (d *Decoder) unmarshalTextInterface(val encoding.TextUnmarshaler) error {
+func (d *Decoder) unmarshalTextInterface(val encoding.TextUnmarshaler, start *xml.StartElement) error {
+	pos := d.elementPos()
 	var buf []byte
 	depth := 1
 	for depth > 0 {
@@ -240,7 +510,7 @@ func (d *Decoder) unmarshalTextInterface(val encoding.TextUnmarshaler) error {
 			depth--
 		}
 	}
-	return val.UnmarshalText(buf)
+	return d.collect(val.UnmarshalText(buf), pos, start.Name.Local)
 }
 
 // unmarshalAttr unmarshals a single XML attribute into val.
@@ -251,6 +521,9 @@ func (d *Decoder) unmarshalAttr(val reflect.Value, attr xml.Attr) error {
 		}
 		val = val.Elem()
 	}
+	if cv, ok := lookupCodec(d.codecs, val, false); ok {
+		return cv.UnmarshalText([]byte(attr.Value))
+	}
 	if val.CanInterface() && val.Type().Implements(unmarshalerAttrType) {
 		// This is an unmarshaler with a non-pointer receiver,
 		// so it's likely to be incorrect, but we do what we're told.
@@ -322,6 +595,13 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 		}
 	}
 
+	pos := d.elementPos()
+
+	if isNilElement(start) && (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) {
+		val.Set(reflect.Zero(val.Type()))
+		return d.Skip()
+	}
+
 	// Load value from interface, but only if the result will be
 	// usefully addressable.
 	if val.Kind() == reflect.Interface && !val.IsNil() {
@@ -338,6 +618,10 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 		val = val.Elem()
 	}
 
+	if cv, ok := lookupCodec(d.codecs, val, false); ok {
+		return d.unmarshalTextInterface(cv, start)
+	}
+
 	if val.CanInterface() && val.Type().Implements(unmarshalerTypeOld) {
 		value := &unmarshalerWrapper{
 			data: val.Interface().(xml.Unmarshaler),
@@ -364,25 +648,27 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 	}
 
 	if val.CanInterface() && val.Type().Implements(textUnmarshalerType) {
-		return d.unmarshalTextInterface(val.Interface().(encoding.TextUnmarshaler))
+		return d.unmarshalTextInterface(val.Interface().(encoding.TextUnmarshaler), start)
 	}
 
 	if val.CanAddr() {
 		pv := val.Addr()
 		if pv.CanInterface() && pv.Type().Implements(textUnmarshalerType) {
-			return d.unmarshalTextInterface(pv.Interface().(encoding.TextUnmarshaler))
+			return d.unmarshalTextInterface(pv.Interface().(encoding.TextUnmarshaler), start)
 		}
 	}
 
 	var (
 		data         []byte
 		saveData     reflect.Value
+		dataInfo     *fieldInfo
 		comment      []byte
 		saveComment  reflect.Value
 		saveXML      reflect.Value
 		saveXMLIndex int
 		saveXMLData  []byte
-		saveAny      reflect.Value
+		saveAny      *fieldInfo
+		seen         []bool
 		sv           reflect.Value
 		tinfo        *typeInfo
 		err          error
@@ -393,10 +679,21 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 		return errors.New("unknown type " + v.Type().String())
 
 	case reflect.Interface:
-		// TODO: For now, simply ignore the field. In the near
-		//       future we may choose to unmarshal the start
-		//       element on it, if not nil.
-		return d.Skip()
+		// Instantiate the type registered for the element,
+		// or ignore the element if there is none.
+		nv, err := d.newInterfaceValue(v.Type(), start)
+		if err != nil {
+			return d.reject(err)
+		}
+		if !nv.IsValid() {
+			d.skipped()
+			return d.Skip()
+		}
+		if err := d.unmarshal(nv, start); err != nil {
+			return err
+		}
+		v.Set(nv)
+		return nil
 
 	case reflect.Slice:
 		typ := v.Type()
@@ -412,12 +709,30 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 		v.Set(reflect.Append(val, reflect.Zero(v.Type().Elem())))
 
 		// Recur to read element into slice.
+		if d.SourceMap != nil {
+			d.fields = append(d.fields, "["+strconv.Itoa(n)+"]")
+			defer func() { d.fields = d.fields[:len(d.fields)-1] }()
+		}
 		if err := d.unmarshal(v.Index(n), start); err != nil {
 			v.SetLen(n)
 			return err
 		}
+		if elem := v.Index(n); elem.Kind() == reflect.Interface && elem.IsNil() {
+			// The element was skipped.
+			v.SetLen(n)
+			return nil
+		}
+		if d.SourceMap != nil {
+			d.SourceMap[d.fieldPath()] = SourceRange{Start: pos, End: d.tokEnd}
+		}
 		return nil
 
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return d.reject(UnmarshalError("cannot unmarshal into " + v.Type().String() + ": map keys must be strings"))
+		}
+		return d.unmarshalMap(v, start)
+
 	case reflect.Bool, reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.String:
 		saveData = v
 
@@ -438,8 +753,8 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 		// Validate and assign element name.
 		if tinfo.xmlname != nil {
 			finfo := tinfo.xmlname
-			if finfo.name != "" && finfo.name != start.Name.Local {
-				return UnmarshalError("expected element type <" + finfo.name + "> but have <" + start.Name.Local + ">")
+			if finfo.name != "" && !d.fieldMatches(finfo, start.Name.Local) {
+				return d.reject(UnmarshalError("expected element type <" + finfo.name + "> but have <" + start.Name.Local + ">"))
 			}
 			if finfo.xmlns != "" && finfo.xmlns != start.Name.Space {
 				e := "expected element <" + finfo.name + "> in name space " + finfo.xmlns + " but have "
@@ -448,7 +763,7 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 				} else {
 					e += start.Name.Space
 				}
-				return UnmarshalError(e)
+				return d.reject(UnmarshalError(e))
 			}
 			fv := finfo.value(sv)
 			if _, ok := fv.Interface().(xml.Name); ok {
@@ -456,8 +771,20 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 			}
 		}
 
+		if tinfo.base != nil && d.lookupXSIType(start) != nil {
+			if err := d.unmarshalBase(tinfo.base.value(sv), start); err != nil {
+				return err
+			}
+			return d.afterUnmarshal(sv, pos, start.Name.Local)
+		}
+
+		if tinfo.defaults {
+			seen = make([]bool, len(tinfo.fields))
+		}
+
 		// Assign attributes.
-		for _, a := range start.Attr {
+		for j, a := range start.Attr {
+			apos := d.attrPosition(start, j, pos)
 			handled := false
 			any := -1
 			for i := range tinfo.fields {
@@ -465,11 +792,21 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 				switch finfo.flags & fMode {
 				case fAttr:
 					strv := finfo.value(sv)
-					if a.Name.Local == finfo.name && (finfo.xmlns == "" || finfo.xmlns == a.Name.Space) {
-						if err := d.unmarshalAttr(strv, a); err != nil {
+					if len(finfo.parents) == 0 && d.fieldMatches(finfo, a.Name.Local) && (finfo.xmlns == "" || finfo.xmlns == a.Name.Space) {
+						ok, err := d.checkField(finfo, a.Value, apos, "@"+a.Name.Local)
+						if err != nil {
 							return err
 						}
+						if ok && !finfo.blank {
+							if err := d.collect(d.unmarshalFieldAttr(finfo, strv, a), apos, "@"+a.Name.Local); err != nil {
+								return err
+							}
+						}
+						d.recordAttr(sv, finfo, start, j)
 						handled = true
+						if seen != nil {
+							seen[i] = true
+						}
 					}
 
 				case fAny | fAttr:
@@ -481,19 +818,31 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 			if !handled && any >= 0 {
 				finfo := &tinfo.fields[any]
 				strv := finfo.value(sv)
-				if err := d.unmarshalAttr(strv, a); err != nil {
+				err := d.unmarshalFieldAttr(finfo, strv, a)
+				if isStringMap(strv.Type()) && localNameClash(start, j) {
+					err = UnmarshalError("attribute " + a.Name.Local + " appears in more than one name space of <" + start.Name.Local + ">")
+				}
+				if err := d.collect(err, apos, "@"+a.Name.Local); err != nil {
 					return err
 				}
+				d.recordAttr(sv, finfo, start, j)
+			} else if !handled {
+				d.skippedAttr(a)
 			}
 		}
 
 		// Determine whether we need to save character data or comments.
 		for i := range tinfo.fields {
 			finfo := &tinfo.fields[i]
+			if len(finfo.parents) > 0 {
+				// Text of nested elements is saved by unmarshalPath.
+				continue
+			}
 			switch finfo.flags & fMode {
 			case fCDATA, fCharData:
 				if !saveData.IsValid() {
 					saveData = finfo.value(sv)
+					dataInfo = finfo
 				}
 
 			case fComment:
@@ -502,8 +851,8 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 				}
 
 			case fAny, fAny | fElement:
-				if !saveAny.IsValid() {
-					saveAny = finfo.value(sv)
+				if saveAny == nil {
+					saveAny = finfo
 				}
 
 			case fInnerXml:
@@ -520,6 +869,18 @@ func (d *Decoder) unmarshal(val reflect.Value, start *xml.StartElement) error {
 		}
 	}
 
+	if d.Unconsumed != nil && !sv.IsValid() {
+		// Only structs have fields for attributes.
+		for _, a := range start.Attr {
+			d.skippedAttr(a)
+		}
+	}
+
+	var counts siblings
+	if sv.IsValid() && tinfo.positions {
+		counts = siblings{}
+	}
+
 	// Find end element.
 	// Process sub-elements along the way.
 Loop:
@@ -536,18 +897,19 @@ Loop:
 		case xml.StartElement:
 			consumed := false
 			if sv.IsValid() {
-				consumed, err = d.unmarshalPath(tinfo, sv, nil, &t)
+				consumed, err = d.unmarshalPath(tinfo, sv, nil, &t, counts.next(&t), seen)
 				if err != nil {
 					return err
 				}
-				if !consumed && saveAny.IsValid() {
+				if !consumed && saveAny != nil {
 					consumed = true
-					if err := d.unmarshal(saveAny, &t); err != nil {
+					if err := d.unmarshalField(saveAny, sv, &t); err != nil {
 						return err
 					}
 				}
 			}
 			if !consumed {
+				d.skipped()
 				if err := d.Skip(); err != nil {
 					return err
 				}
@@ -574,24 +936,13 @@ Loop:
 		}
 	}
 
-	if saveData.IsValid() && saveData.CanInterface() && saveData.Type().Implements(textUnmarshalerType) {
-		if err := saveData.Interface().(encoding.TextUnmarshaler).UnmarshalText(data); err != nil {
+	if seen != nil {
+		if err := d.setDefaults(tinfo, sv, seen, start, pos); err != nil {
 			return err
 		}
-		saveData = reflect.Value{}
-	}
-
-	if saveData.IsValid() && saveData.CanAddr() {
-		pv := saveData.Addr()
-		if pv.CanInterface() && pv.Type().Implements(textUnmarshalerType) {
-			if err := pv.Interface().(encoding.TextUnmarshaler).UnmarshalText(data); err != nil {
-				return err
-			}
-			saveData = reflect.Value{}
-		}
 	}
 
-	if err := copyValue(saveData, data); err != nil {
+	if err := d.collect(d.setText(saveData, dataInfo, data), pos, start.Name.Local); err != nil {
 		return err
 	}
 
@@ -602,16 +953,45 @@ Loop:
 		t.Set(reflect.ValueOf(comment))
 	}
 
-	switch t := saveXML; t.Kind() {
+	setInnerXML(saveXML, saveXMLData)
+
+	if sv.IsValid() {
+		return d.afterUnmarshal(sv, pos, start.Name.Local)
+	}
+	return nil
+}
+
+// setText stores the character data in val, the value of the text
+// field dataInfo. Either may be missing.
+func (d *Decoder) setText(val reflect.Value, dataInfo *fieldInfo, data []byte) error {
+	if cv, ok := lookupCodec(d.codecs, val, false); ok {
+		return cv.UnmarshalText(data)
+	}
+	if val.IsValid() && dataInfo != nil && dataInfo.timeFormat != "" {
+		return dataInfo.setTime(val, string(data))
+	}
+	if val.IsValid() && val.CanInterface() && val.Type().Implements(textUnmarshalerType) {
+		return val.Interface().(encoding.TextUnmarshaler).UnmarshalText(data)
+	}
+	if val.IsValid() && val.CanAddr() {
+		pv := val.Addr()
+		if pv.CanInterface() && pv.Type().Implements(textUnmarshalerType) {
+			return pv.Interface().(encoding.TextUnmarshaler).UnmarshalText(data)
+		}
+	}
+	return copyValue(val, data)
+}
+
+// setInnerXML stores the raw XML data in val, a string or a byte slice.
+func setInnerXML(val reflect.Value, data []byte) {
+	switch val.Kind() {
 	case reflect.String:
-		t.SetString(string(saveXMLData))
+		val.SetString(string(data))
 	case reflect.Slice:
-		if t.Type().Elem().Kind() == reflect.Uint8 {
-			t.Set(reflect.ValueOf(saveXMLData))
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			val.Set(reflect.ValueOf(data))
 		}
 	}
-
-	return nil
 }
 
 func copyValue(dst reflect.Value, src []byte) (err error) {
@@ -682,37 +1062,93 @@ func copyValue(dst reflect.Value, src []byte) (err error) {
 	return nil
 }
 
+// recordAttr records the input range of the i'th attribute of start,
+// which was stored in the field of sv described by finfo.
+func (d *Decoder) recordAttr(sv reflect.Value, finfo *fieldInfo, start *xml.StartElement, i int) {
+	if d.SourceMap == nil || len(d.attrPos) != len(start.Attr) {
+		return
+	}
+	d.SourceMap[d.fieldPath(sv.Type().FieldByIndex(finfo.idx).Name)] = d.attrPos[i]
+}
+
+// attrPosition returns the start position of the i'th attribute of
+// start, or pos if it is not known.
+func (d *Decoder) attrPosition(start *xml.StartElement, i int, pos Position) Position {
+	if len(d.attrPos) != len(start.Attr) {
+		return pos
+	}
+	return d.attrPos[i].Start
+}
+
+// unmarshalPathAttrs stores the attributes of start in the fields of sv
+// for attributes of elements nested at the path given by parents and
+// start.
+func (d *Decoder) unmarshalPathAttrs(tinfo *typeInfo, sv reflect.Value, parents []pathStep, start *xml.StartElement, n int, seen []bool) error {
+	pos := d.elementPos()
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fMode != fAttr || len(finfo.parents) != len(parents)+1 || !d.onPath(finfo, parents) || !d.stepMatches(finfo, len(parents), start, n) {
+			continue
+		}
+		for j, a := range start.Attr {
+			if !d.fieldMatches(finfo, a.Name.Local) || finfo.xmlns != "" && finfo.xmlns != a.Name.Space {
+				continue
+			}
+			apos := d.attrPosition(start, j, pos)
+			ok, err := d.checkField(finfo, a.Value, apos, "@"+a.Name.Local)
+			if err != nil {
+				return err
+			}
+			if ok && !finfo.blank {
+				if err := d.collect(d.unmarshalFieldAttr(finfo, finfo.value(sv), a), apos, "@"+a.Name.Local); err != nil {
+					return err
+				}
+			}
+			d.recordAttr(sv, finfo, start, j)
+			if seen != nil {
+				seen[i] = true
+			}
+			break
+		}
+	}
+	return nil
+}
+
 // unmarshalPath walks down an XML structure looking for wanted
 // paths, and calls unmarshal on them.
 // The consumed result tells whether XML elements have been consumed
 // from the Decoder until start's matching end element, or if it's
 // still untouched because start is uninteresting for sv's fields.
-func (d *Decoder) unmarshalPath(tinfo *typeInfo, sv reflect.Value, parents []string, start *xml.StartElement) (consumed bool, err error) {
+//
+// If seen is not nil, the field that receives the element is marked in it.
+func (d *Decoder) unmarshalPath(tinfo *typeInfo, sv reflect.Value, parents []pathStep, start *xml.StartElement, n int, seen []bool) (consumed bool, err error) {
+	if err := d.unmarshalPathAttrs(tinfo, sv, parents, start, n, seen); err != nil {
+		return false, err
+	}
 	recurse := false
-Loop:
 	for i := range tinfo.fields {
 		finfo := &tinfo.fields[i]
-		if finfo.flags&fElement == 0 || len(finfo.parents) < len(parents) || finfo.xmlns != "" && finfo.xmlns != start.Name.Space {
+		// Attribute and text fields with parents only take part
+		// in the walk down the path.
+		nested := finfo.flags&fElement == 0 && len(finfo.parents) > 0
+		if finfo.flags&fElement == 0 && !nested || len(finfo.parents) < len(parents) || !nested && finfo.xmlns != "" && finfo.xmlns != start.Name.Space {
 			continue
 		}
-		for j := range parents {
-			if parents[j] != finfo.parents[j] {
-				continue Loop
-			}
+		if !d.onPath(finfo, parents) {
+			continue
 		}
-		if len(finfo.parents) == len(parents) && finfo.name == start.Name.Local {
+		if !nested && len(finfo.parents) == len(parents) && d.fieldMatches(finfo, start.Name.Local) && finfo.selects(len(parents), start, n) {
 			// It's a perfect match, unmarshal the field.
-			return true, d.unmarshal(finfo.value(sv), start)
+			if seen != nil {
+				seen[i] = true
+			}
+			return true, d.unmarshalField(finfo, sv, start)
 		}
-		if len(finfo.parents) > len(parents) && finfo.parents[len(parents)] == start.Name.Local {
+		if len(finfo.parents) > len(parents) && d.stepMatches(finfo, len(parents), start, n) {
 			// It's a prefix for the field. Break and recurse
 			// since it's not ok for one field path to be itself
 			// the prefix for another field path.
 			recurse = true
-
-			// We can reuse the same slice as long as we
-			// don't try to append to it.
-			parents = finfo.parents[:len(parents)+1]
 			break
 		}
 	}
@@ -722,25 +1158,76 @@ Loop:
 	}
 	// The element is not a perfect match for any field, but one
 	// or more fields have the path to this element as a parent
-	// prefix. Recurse and attempt to match these.
+	// prefix. Recurse and attempt to match these, saving the text
+	// of the element for the fields that have it as their path.
+	pos := d.elementPos()
+	parents = append(parents[:len(parents):len(parents)], pathStep{start, n})
+	var text, inner *fieldInfo
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fElement != 0 || len(finfo.parents) != len(parents) || !d.onPath(finfo, parents) {
+			continue
+		}
+		switch finfo.flags & fMode {
+		case fCDATA, fCharData:
+			if text == nil {
+				text = finfo
+			}
+		case fInnerXml:
+			if inner == nil {
+				inner = finfo
+			}
+		}
+	}
+	var data []byte
+	var counts siblings
+	if tinfo.positions {
+		counts = siblings{}
+	}
+	saveXMLIndex := 0
+	if inner != nil {
+		if d.saved == nil {
+			d.saved = new(bytes.Buffer)
+		} else {
+			saveXMLIndex = d.savedOffset()
+		}
+	}
 	for {
+		var savedOffset int
+		if inner != nil {
+			savedOffset = d.savedOffset()
+		}
 		var tok xml.Token
 		tok, err = d.Token()
 		if err != nil {
 			return true, err
 		}
 		switch t := tok.(type) {
+		case xml.CharData:
+			if text != nil {
+				data = append(data, t...)
+			}
 		case xml.StartElement:
-			consumed2, err := d.unmarshalPath(tinfo, sv, parents, &t)
+			consumed2, err := d.unmarshalPath(tinfo, sv, parents, &t, counts.next(&t), seen)
 			if err != nil {
 				return true, err
 			}
 			if !consumed2 {
+				d.skipped()
 				if err := d.Skip(); err != nil {
 					return true, err
 				}
 			}
 		case xml.EndElement:
+			if inner != nil {
+				setInnerXML(inner.value(sv), d.saved.Bytes()[saveXMLIndex:savedOffset])
+				if saveXMLIndex == 0 {
+					d.saved = nil
+				}
+			}
+			if text != nil {
+				return true, d.collect(d.setText(text.value(sv), text, data), pos, start.Name.Local)
+			}
 			return true, nil
 		}
 	}
@@ -1080,3 +1080,478 @@ func TestUnmarshalWhitespaceAttrs(t *testing.T) {
 		t.Fatalf("whitespace attrs: Unmarshal:\nhave: %#+v\nwant: %#+v", v, want)
 	}
 }
+
+type CollectItem struct {
+	ID    int     `xml:"id,attr"`
+	Price float64 `xml:"price"`
+	Count int     `xml:"count"`
+}
+
+type CollectOrder struct {
+	XMLName xml.Name      `xml:"order"`
+	Number  int           `xml:"number"`
+	Items   []CollectItem `xml:"item"`
+	Paid    bool          `xml:"paid"`
+}
+
+func TestUnmarshalCollectErrors(t *testing.T) {
+	const input = `<order><number>x1</number>` +
+		`<item id="a"><price>1.5</price><count>2</count></item>` +
+		`<item id="2"><price>cheap</price><count>3</count></item>` +
+		`<paid>yes</paid></order>`
+
+	var strict CollectOrder
+	if err := Unmarshal([]byte(input), &strict); err == nil {
+		t.Fatalf("Unmarshal without CollectErrors succeeded")
+	} else if _, ok := err.(ErrorList); ok {
+		t.Fatalf("Unmarshal without CollectErrors returned %T", err)
+	}
+
+	var v CollectOrder
+	d := NewDecoder(strings.NewReader(input))
+	d.CollectErrors = true
+	err := d.Decode(&v)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("Decode = %v, want ErrorList", err)
+	}
+	var paths []string
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	want := []string{"/order/number", "/order/item/@id", "/order/item/price", "/order/paid"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("error paths = %q, want %q", paths, want)
+	}
+	if len(v.Items) != 2 || v.Items[0].Price != 1.5 || v.Items[1].ID != 2 || v.Items[1].Count != 3 {
+		t.Errorf("valid values were not decoded: %+v", v.Items)
+	}
+
+	// A second Decode on the same Decoder starts with a clean list.
+	d = NewDecoder(strings.NewReader(`<order><number>7</number></order>`))
+	d.CollectErrors = true
+	if err := d.Decode(&v); err != nil || v.Number != 7 {
+		t.Errorf("Decode = %v, Number = %d", err, v.Number)
+	}
+}
+
+func TestUnmarshalCollectErrorsName(t *testing.T) {
+	type Wrapper struct {
+		Order []CollectOrder `xml:",any"`
+		Tail  string         `xml:"tail"`
+	}
+	d := NewDecoder(strings.NewReader(`<w><invoice><number>1</number></invoice><tail>ok</tail></w>`))
+	d.CollectErrors = true
+	var v Wrapper
+	err := d.Decode(&v)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 1 || errs[0].Path != "/w/invoice" {
+		t.Fatalf("Decode = %v, want a single error for /w/invoice", err)
+	}
+	if _, ok := errs[0].Err.(UnmarshalError); !ok {
+		t.Errorf("recorded error is %T, want UnmarshalError", errs[0].Err)
+	}
+	if v.Tail != "ok" {
+		t.Errorf("Tail = %q, want %q", v.Tail, "ok")
+	}
+}
+
+func TestUnmarshalSourceMap(t *testing.T) {
+	type Item struct {
+		ID    string `xml:"id,attr"`
+		Price string `xml:"price"`
+	}
+	type Order struct {
+		Number string `xml:"head>number"`
+		Items  []Item `xml:"item"`
+		Extra  []byte `xml:",any"`
+	}
+	const input = "<order>\n" +
+		"<head><number>7</number></head>\n" +
+		"<item id=\"a\"><price>1</price></item>\n" +
+		"<item id=\"b\"><price>2</price></item>\n" +
+		"<note>x</note>\n" +
+		"</order>"
+	d := NewDecoder(strings.NewReader(input))
+	d.SourceMap = SourceMap{}
+	var v Order
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	want := SourceMap{
+		"Number":         {Position{14, 2, 7}, Position{32, 2, 25}},
+		"Items[0]":       {Position{40, 3, 1}, Position{76, 3, 37}},
+		"Items[0].ID":    {Position{46, 3, 7}, Position{52, 3, 13}},
+		"Items[0].Price": {Position{53, 3, 14}, Position{69, 3, 30}},
+		"Items[1]":       {Position{77, 4, 1}, Position{113, 4, 37}},
+		"Items[1].ID":    {Position{83, 4, 7}, Position{89, 4, 13}},
+		"Items[1].Price": {Position{90, 4, 14}, Position{106, 4, 30}},
+		"Extra":          {Position{114, 5, 1}, Position{128, 5, 15}},
+	}
+	if !reflect.DeepEqual(d.SourceMap, want) {
+		t.Errorf("SourceMap =\n%v\nwant\n%v", d.SourceMap, want)
+	}
+	for path, text := range map[string]string{
+		"Items[1].ID":    `id="b"`,
+		"Items[1].Price": `<price>2</price>`,
+	} {
+		r := d.SourceMap[path]
+		if have := input[r.Start.Offset:r.End.Offset]; have != text {
+			t.Errorf("%s covers %q, want %q", path, have, text)
+		}
+	}
+}
+
+func TestUnmarshalUnconsumed(t *testing.T) {
+	type Item struct {
+		Name string `xml:"name"`
+	}
+	type Order struct {
+		ID    string `xml:"id,attr"`
+		Items []Item `xml:"list>item"`
+	}
+	const input = `<order xmlns:x="urn:x" id="1" x:channel="web">` +
+		`<list><item><name lang="en">a</name><sku>1</sku></item><item><name>b</name><sku>2</sku></item><total/></list>` +
+		`<note>late</note></order>`
+
+	d := NewDecoder(strings.NewReader(input))
+	d.Unconsumed = UnconsumedReport{}
+	var v Order
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	want := UnconsumedReport{
+		"/order/@channel":             1,
+		"/order/list/item/name/@lang": 1,
+		"/order/list/item/sku":        2,
+		"/order/list/total":           1,
+		"/order/note":                 1,
+	}
+	if !reflect.DeepEqual(d.Unconsumed, want) {
+		t.Errorf("Unconsumed = %v, want %v", d.Unconsumed, want)
+	}
+	if v.ID != "1" || len(v.Items) != 2 || v.Items[1].Name != "b" {
+		t.Errorf("Decode stored %+v", v)
+	}
+}
+
+type TimeFormats struct {
+	Date    time.Time   `xml:"date,attr,timeformat=2006-01-02"`
+	Local   time.Time   `xml:"local,timeformat=02.01.2006T15:04,timezone=Europe/Moscow"`
+	Epoch   *time.Time  `xml:"epoch,unix"`
+	Millis  []time.Time `xml:"ms,unixmilli"`
+	Offset  time.Time   `xml:"offset,timezone=+03:00"`
+	Default time.Time   `xml:"default"`
+	Text    time.Time   `xml:",chardata,timeformat=15:04"`
+}
+
+func TestUnmarshalTimeFormat(t *testing.T) {
+	const input = `<t date="2021-03-04">` +
+		`<local>05.06.2021T07:08</local>` +
+		`<epoch>1600000000</epoch>` +
+		`<ms>1600000000123</ms><ms>0</ms>` +
+		`<offset>2021-03-04T05:06:07Z</offset>` +
+		`<default>2021-03-04T05:06:07+02:00</default>` +
+		` 10:11 </t>`
+	var v TimeFormats
+	if err := Unmarshal([]byte(input), &v); err != nil {
+		t.Fatal(err)
+	}
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip(err)
+	}
+	checks := []struct {
+		name      string
+		got, want time.Time
+	}{
+		{"Date", v.Date, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"Local", v.Local, time.Date(2021, 6, 5, 7, 8, 0, 0, moscow)},
+		{"Epoch", *v.Epoch, time.Unix(1600000000, 0)},
+		{"Millis[0]", v.Millis[0], time.Unix(1600000000, 123000000)},
+		{"Millis[1]", v.Millis[1], time.Unix(0, 0)},
+		{"Offset", v.Offset, time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)},
+		{"Default", v.Default, time.Date(2021, 3, 4, 3, 6, 7, 0, time.UTC)},
+		{"Text", v.Text, time.Date(0, 1, 1, 10, 11, 0, 0, time.UTC)},
+	}
+	for _, c := range checks {
+		if !c.got.Equal(c.want) {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if v.Local.Location().String() != moscow.String() {
+		t.Errorf("Local location = %v, want %v", v.Local.Location(), moscow)
+	}
+
+	var bad TimeFormats
+	err = Unmarshal([]byte(`<t date="04.03.2021"/>`), &bad)
+	if _, ok := err.(*time.ParseError); !ok {
+		t.Errorf("bad date: got error %v, want *time.ParseError", err)
+	}
+
+	var wrongType struct {
+		N int `xml:"n,unix"`
+	}
+	if err := Unmarshal([]byte(`<t><n>1</n></t>`), &wrongType); err == nil || !strings.Contains(err.Error(), "requires time.Time") {
+		t.Errorf("time option on int: got error %v", err)
+	}
+}
+
+func TestTimeFormatQuoted(t *testing.T) {
+	// go vet reports spaces in xml tags, but not in xmlutils tags.
+	type Feed struct {
+		XMLName struct{}  `xml:"feed"`
+		Pub     time.Time `xmlutils:"pub,timeformat='Mon, 02 Jan 2006',omitempty"`
+		Updated time.Time `xmlutils:"updated,attr,timeformat='02 Jan, 15:04'"`
+	}
+	const data = `<feed updated="05 Mar, 10:30"><pub>Tue, 05 Mar 2024</pub></feed>`
+	var v Feed
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC); !v.Pub.Equal(want) {
+		t.Errorf("Pub = %v, want %v", v.Pub, want)
+	}
+	if want := time.Date(0, 3, 5, 10, 30, 0, 0, time.UTC); !v.Updated.Equal(want) {
+		t.Errorf("Updated = %v, want %v", v.Updated, want)
+	}
+	out, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, data)
+	}
+}
+
+type DefaultPrice struct {
+	XMLName  xml.Name  `xml:"price"`
+	Currency string    `xml:"currency,attr,default=RUB"`
+	Amount   float64   `xml:"amount,default=1.5"`
+	Qty      int       `xml:"qty,attr,default=1"`
+	Date     time.Time `xml:"date,attr,timeformat=2006-01-02,default=2020-01-01"`
+	Note     string    `xml:"info>note,default=none"`
+}
+
+func TestUnmarshalDefault(t *testing.T) {
+	tests := []struct {
+		input string
+		want  DefaultPrice
+	}{{
+		input: `<price/>`,
+		want: DefaultPrice{
+			XMLName:  xml.Name{Local: "price"},
+			Currency: "RUB",
+			Amount:   1.5,
+			Qty:      1,
+			Date:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			Note:     "none",
+		},
+	}, {
+		input: `<price currency="USD" qty="3" date="2021-02-03"><amount>7</amount><info><note>x</note></info></price>`,
+		want: DefaultPrice{
+			XMLName:  xml.Name{Local: "price"},
+			Currency: "USD",
+			Amount:   7,
+			Qty:      3,
+			Date:     time.Date(2021, 2, 3, 0, 0, 0, 0, time.UTC),
+			Note:     "x",
+		},
+	}, {
+		// Present but empty values are not replaced by the default.
+		input: `<price currency=""><info><note></note></info></price>`,
+		want: DefaultPrice{
+			XMLName: xml.Name{Local: "price"},
+			Amount:  1.5,
+			Qty:     1,
+			Date:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}}
+	for _, tt := range tests {
+		var got DefaultPrice
+		if err := Unmarshal([]byte(tt.input), &got); err != nil {
+			t.Errorf("%s: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\nhave %+v\nwant %+v", tt.input, got, tt.want)
+		}
+	}
+
+	var bad struct {
+		N int `xml:"n,attr,default=many"`
+	}
+	d := NewDecoder(strings.NewReader(`<x/>`))
+	d.CollectErrors = true
+	err := d.Decode(&bad)
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 1 || list[0].Path != "/x/@n" {
+		t.Errorf("bad default: got error %#v, want one error at /x/@n", err)
+	}
+}
+
+type AliasLine struct {
+	XMLName  struct{} `xml:"line|item"`
+	Quantity int      `xml:"Quantity|Qty"`
+	SKU      string   `xml:"sku|code,attr"`
+}
+
+func TestUnmarshalAliases(t *testing.T) {
+	for _, input := range []string{
+		`<line sku="A1"><Quantity>2</Quantity></line>`,
+		`<item code="A1"><Qty>2</Qty></item>`,
+	} {
+		var v AliasLine
+		if err := Unmarshal([]byte(input), &v); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", input, err)
+		}
+		if v.Quantity != 2 || v.SKU != "A1" {
+			t.Errorf("Unmarshal(%s) = %+v", input, v)
+		}
+	}
+
+	out, err := Marshal(&AliasLine{Quantity: 3, SKU: "B2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<line sku="B2"><Quantity>3</Quantity></line>`; string(out) != want {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, want)
+	}
+
+	var conflict struct {
+		Quantity int `xml:"Quantity|Qty"`
+		Qty      int `xml:"Qty"`
+	}
+	if _, ok := Unmarshal([]byte(`<x/>`), &conflict).(*TagPathError); !ok {
+		t.Errorf("conflicting alias: want *TagPathError")
+	}
+	var parent struct {
+		Qty int `xml:"a|b>Qty"`
+	}
+	if err := Unmarshal([]byte(`<x/>`), &parent); err == nil {
+		t.Errorf("alias in parent: want error")
+	}
+}
+
+type NestedAttrDoc struct {
+	XMLName struct{} `xml:"doc"`
+	ID      int      `xml:"meta>id>value,attr"`
+	Source  string   `xml:"meta>id@source,omitempty"`
+	Lang    string   `xml:"meta>lang@code,default=ru"`
+	Title   string   `xml:"meta>title"`
+	Kind    string   `xml:"@kind"`
+}
+
+func TestNestedAttrs(t *testing.T) {
+	const data = `<doc kind="note"><meta><id value="5" source="crm"></id><lang code="en"></lang><title>Hi</title></meta></doc>`
+	want := NestedAttrDoc{ID: 5, Source: "crm", Lang: "en", Title: "Hi", Kind: "note"}
+
+	var v NestedAttrDoc
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v != want {
+		t.Errorf("Unmarshal:\nhave %+v\nwant %+v", v, want)
+	}
+	out, err := Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, data)
+	}
+
+	// Absent attributes take their defaults and empty ones are omitted.
+	v = NestedAttrDoc{}
+	if err := Unmarshal([]byte(`<doc><meta><id value="7"/></meta></doc>`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.ID != 7 || v.Lang != "ru" {
+		t.Errorf("Unmarshal with defaults = %+v", v)
+	}
+	out, err = Marshal(&NestedAttrDoc{ID: 7, Lang: "ru"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<doc kind=""><meta><id value="7"></id><lang code="ru"></lang><title></title></meta></doc>`; string(out) != want {
+		t.Errorf("Marshal with empty values:\nhave %s\nwant %s", out, want)
+	}
+
+	d := NewDecoder(strings.NewReader(`<doc><meta><id value="x"/></meta></doc>`))
+	d.CollectErrors = true
+	if errs, ok := d.Decode(&v).(ErrorList); !ok || len(errs) != 1 || errs[0].Path != "/doc/meta/id/@value" {
+		t.Errorf("Decode bad value: got %v, want error at /doc/meta/id/@value", errs)
+	}
+
+	// Attributes are written once when the fields of a path are not
+	// next to each other.
+	split := struct {
+		XMLName struct{} `xml:"doc"`
+		X       string   `xml:"a>x"`
+		B       string   `xml:"b"`
+		ID      string   `xml:"a@id"`
+		Y       string   `xml:"a>y"`
+	}{X: "1", B: "2", ID: "3", Y: "4"}
+	out, err = Marshal(&split)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<doc><a id="3"><x>1</x></a><b>2</b><a><y>4</y></a></doc>`; string(out) != want {
+		t.Errorf("Marshal split path:\nhave %s\nwant %s", out, want)
+	}
+
+	var conflict struct {
+		ID   string `xml:"meta>id"`
+		Attr string `xml:"meta>id@value"`
+	}
+	if _, ok := Unmarshal([]byte(`<x/>`), &conflict).(*TagPathError); !ok {
+		t.Errorf("attribute inside element field: want *TagPathError")
+	}
+}
+
+type NestedTextDoc struct {
+	XMLName struct{}  `xml:"doc"`
+	Weight  float64   `xml:"item>weight,chardata"`
+	Unit    string    `xml:"item>weight@unit"`
+	Note    string    `xml:"item>note,cdata"`
+	Name    string    `xml:"item>name"`
+	When    time.Time `xml:"item>when,chardata,timeformat=2006-01-02"`
+	Raw     string    `xml:"extra,innerxml"`
+}
+
+func TestNestedText(t *testing.T) {
+	const data = `<doc><item><weight unit="kg">1.5</weight><note><![CDATA[a<b]]></note><name>box</name><when>2020-02-03</when></item>` +
+		`<extra><a x="1">y</a></extra></doc>`
+	want := NestedTextDoc{
+		Weight: 1.5,
+		Unit:   "kg",
+		Note:   "a<b",
+		Raw:    `<a x="1">y</a>`,
+		Name:   "box",
+		When:   time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	var v NestedTextDoc
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal:\nhave %+v\nwant %+v", v, want)
+	}
+
+	out, err := Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, data)
+	}
+
+	var conflict struct {
+		Item string `xml:"item"`
+		Text string `xml:"item>weight,chardata"`
+	}
+	if _, ok := Unmarshal([]byte(`<x/>`), &conflict).(*TagPathError); !ok {
+		t.Errorf("text inside element field: want *TagPathError")
+	}
+}
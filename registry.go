@@ -0,0 +1,286 @@
+package xmlutils
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	xsiURL    = "http://www.w3.org/2001/XMLSchema-instance"
+	xsiPrefix = "xsi"
+)
+
+var elementTypes sync.Map // map[xml.Name]reflect.Type
+
+var xsiTypes sync.Map     // map[xml.Name]reflect.Type
+var xsiTypeNames sync.Map // map[reflect.Type]xml.Name
+
+// RegisterType makes all decoders use the type of v for elements named
+// name when they decode into an interface value, such as a field of
+// type interface{} or a []Shape slice. If v is a pointer, the interface
+// receives a pointer to a new value, otherwise the value itself.
+// A name without a name space matches elements in any name space that
+// have no registration of their own.
+//
+// Elements without a registered type are decoded as a *Node if that
+// fits the interface, and are skipped otherwise.
+func RegisterType(name xml.Name, v interface{}) {
+	elementTypes.Store(name, reflect.TypeOf(v))
+}
+
+// RegisterType makes the decoder use the type of v for elements named
+// name. See the package-level RegisterType.
+func (d *Decoder) RegisterType(name xml.Name, v interface{}) {
+	if d.types == nil {
+		d.types = make(map[xml.Name]reflect.Type)
+	}
+	d.types[name] = reflect.TypeOf(v)
+}
+
+// RegisterXSIType binds the schema type name to the type of v.
+// Decoders use the type of v for elements carrying xsi:type="name"
+// when they decode into an interface value, in preference to the
+// element name. Encoders add xsi:type="name" to the elements of
+// values of that type held by an interface, declaring the name
+// spaces needed. As with RegisterType, v may be a pointer.
+//
+// A struct may embed an interface without a tag as its base field.
+// An element decoded into the struct whose xsi:type has a registered
+// type is decoded into the base field instead of the other fields,
+// and a struct whose base field is not nil encodes as the value it
+// holds, under the name of the struct element.
+func RegisterXSIType(name xml.Name, v interface{}) {
+	typ := reflect.TypeOf(v)
+	xsiTypes.Store(name, typ)
+	xsiTypeNames.Store(baseType(typ), name)
+}
+
+// RegisterXSIType binds the schema type name to the type of v for
+// the decoder. See the package-level RegisterXSIType.
+func (d *Decoder) RegisterXSIType(name xml.Name, v interface{}) {
+	if d.xsiTypes == nil {
+		d.xsiTypes = make(map[xml.Name]reflect.Type)
+	}
+	d.xsiTypes[name] = reflect.TypeOf(v)
+}
+
+// RegisterXSIType binds the schema type name to the type of v for
+// the encoder. See the package-level RegisterXSIType.
+func (enc *Encoder) RegisterXSIType(name xml.Name, v interface{}) {
+	if enc.xsiTypeNames == nil {
+		enc.xsiTypeNames = make(map[reflect.Type]xml.Name)
+	}
+	enc.xsiTypeNames[baseType(reflect.TypeOf(v))] = name
+}
+
+// baseType returns typ with any pointers removed.
+func baseType(typ reflect.Type) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ
+}
+
+// isXSIAttr reports whether name is the attribute local of the XML
+// schema instance name space. An undeclared xsi prefix is accepted too.
+func isXSIAttr(name xml.Name, local string) bool {
+	return name.Local == local && (name.Space == xsiURL || name.Space == xsiPrefix)
+}
+
+// lookupXSIType returns the type registered for the xsi:type
+// attribute of start, if it has one.
+func (d *Decoder) lookupXSIType(start *xml.StartElement) reflect.Type {
+	for _, a := range start.Attr {
+		if !isXSIAttr(a.Name, "type") {
+			continue
+		}
+		name := xml.Name{Local: strings.TrimSpace(a.Value)}
+		if i := strings.Index(name.Local, ":"); i >= 0 {
+			name.Space, name.Local = name.Local[:i], name.Local[i+1:]
+		}
+		d.translate(&name, true)
+		if typ := d.xsiTypes[name]; typ != nil {
+			return typ
+		}
+		if typ, ok := xsiTypes.Load(name); ok {
+			return typ.(reflect.Type)
+		}
+		return nil
+	}
+	return nil
+}
+
+// xsiTypeName returns the schema type name registered for typ.
+func (p *printer) xsiTypeName(typ reflect.Type) (xml.Name, bool) {
+	if name, ok := p.encoder.xsiTypeNames[typ]; ok {
+		return name, true
+	}
+	if name, ok := xsiTypeNames.Load(typ); ok {
+		return name.(xml.Name), true
+	}
+	return xml.Name{}, false
+}
+
+// hasXSIType reports whether attrs sets xsi:type already.
+func hasXSIType(attrs []xml.Attr) bool {
+	for _, a := range attrs {
+		if isXSIAttr(a.Name, "type") {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalNil writes an empty element with xsi:nil="true" for the
+// nil value of a nillable field.
+func (p *printer) marshalNil(finfo *fieldInfo, startTemplate *xml.StartElement) error {
+	start := xml.StartElement{Name: xml.Name{Space: finfo.xmlns, Local: finfo.name}}
+	if startTemplate != nil {
+		start.Name = startTemplate.Name
+		start.Attr = append(start.Attr, startTemplate.Attr...)
+	} else {
+		finfo.addPredicateAttr(&start)
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Space: xsiURL, Local: "nil"}, Value: "true"})
+	if err := p.writeStart(&start); err != nil {
+		return err
+	}
+	return p.writeEnd(start.Name)
+}
+
+var xsiNeeded sync.Map // map[reflect.Type]bool
+
+// needsXSI reports whether values of typ may contain nillable fields.
+func needsXSI(typ reflect.Type) bool {
+	if need, ok := xsiNeeded.Load(typ); ok {
+		return need.(bool)
+	}
+	need := hasNillable(typ, map[reflect.Type]bool{})
+	xsiNeeded.Store(typ, need)
+	return need
+}
+
+func hasNillable(typ reflect.Type, seen map[reflect.Type]bool) bool {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || seen[typ] {
+		return false
+	}
+	seen[typ] = true
+	u := &Utils{Marshal: true}
+	tinfo, err := u.getTypeInfo(typ)
+	if err != nil {
+		return false
+	}
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fNillable != 0 || hasNillable(typ.FieldByIndex(finfo.idx).Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNilElement reports whether start carries xsi:nil="true".
+func isNilElement(start *xml.StartElement) bool {
+	for _, a := range start.Attr {
+		if isXSIAttr(a.Name, "nil") {
+			v := strings.TrimSpace(a.Value)
+			return v == "true" || v == "1"
+		}
+	}
+	return false
+}
+
+// writeXSIType writes the xsi:type attribute for the schema type name
+// together with the name space declarations it needs.
+func (p *printer) writeXSIType(name xml.Name) {
+	p.WriteByte(' ')
+	value := name.Local
+	if name.Space != "" {
+		value = p.createAttrPrefix(name.Space) + ":" + value
+	}
+	p.WriteString(p.createAttrPrefix(xsiURL))
+	p.WriteString(`:type="`)
+	p.EscapeString(value)
+	p.WriteByte('"')
+}
+
+// A Node is a generic XML element. It is the value decoded into an
+// interface for elements that have no registered type, and it encodes
+// back to the same element.
+type Node struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Content string     `xml:",chardata"`
+	Nodes   []Node     `xml:",any"`
+}
+
+var nodePtrType = reflect.TypeOf(&Node{})
+
+// lookupType returns the type registered for name, first on the
+// decoder and then on the package, or nil.
+func (d *Decoder) lookupType(name xml.Name) reflect.Type {
+	for _, n := range []xml.Name{name, {Local: name.Local}} {
+		if typ := d.types[n]; typ != nil {
+			return typ
+		}
+		if typ, ok := elementTypes.Load(n); ok {
+			return typ.(reflect.Type)
+		}
+		if name.Space == "" {
+			break
+		}
+	}
+	return nil
+}
+
+// unmarshalBase decodes start into the base field fv of a struct, an
+// embedded interface, using the type registered for the xsi:type
+// attribute of start.
+func (d *Decoder) unmarshalBase(fv reflect.Value, start *xml.StartElement) error {
+	nv, err := d.newInterfaceValue(fv.Type(), start)
+	if err != nil {
+		return d.reject(err)
+	}
+	if err := d.unmarshal(nv, start); err != nil {
+		return err
+	}
+	fv.Set(nv)
+	return nil
+}
+
+// newInterfaceValue returns a new value to decode the element start
+// into for an interface of type iface. The type registered for its
+// xsi:type attribute comes first, then the one for its name. It
+// returns an invalid value if there is no registered type and a *Node
+// does not fit.
+func (d *Decoder) newInterfaceValue(iface reflect.Type, start *xml.StartElement) (reflect.Value, error) {
+	name := start.Name
+	typ := d.lookupXSIType(start)
+	if typ == nil {
+		typ = d.lookupType(name)
+	}
+	if typ == nil {
+		if !nodePtrType.AssignableTo(iface) {
+			return reflect.Value{}, nil
+		}
+		typ = nodePtrType
+	}
+	var v reflect.Value
+	if typ.Kind() == reflect.Ptr {
+		v = reflect.New(typ.Elem())
+	} else {
+		v = reflect.New(typ).Elem()
+	}
+	switch {
+	case v.Type().AssignableTo(iface):
+		return v, nil
+	case v.CanAddr() && v.Addr().Type().AssignableTo(iface):
+		return v.Addr(), nil
+	}
+	return reflect.Value{}, UnmarshalError("type " + typ.String() + " registered for <" + name.Local + "> does not implement " + iface.String())
+}
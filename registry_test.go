@@ -0,0 +1,239 @@
+package xmlutils
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Shape interface {
+	Area() float64
+}
+
+type Circle struct {
+	R float64 `xml:"r,attr"`
+}
+
+func (c Circle) Area() float64 { return 3 * c.R * c.R }
+
+type Square struct {
+	Side float64 `xml:"side"`
+}
+
+func (s *Square) Area() float64 { return s.Side * s.Side }
+
+type Drawing struct {
+	Shapes []Shape     `xml:",any"`
+	Main   Shape       `xml:"main>square"`
+	Extra  interface{} `xml:"extra"`
+}
+
+func init() {
+	RegisterType(xml.Name{Local: "circle"}, Circle{})
+}
+
+func TestUnmarshalRegisteredTypes(t *testing.T) {
+	const input = `<drawing xmlns:s="urn:shapes">` +
+		`<circle r="1"/><s:square><side>2</side></s:square><triangle/><s:circle r="3"/>` +
+		`<main><square><side>4</side></square></main>` +
+		`<extra id="7">text<child>x</child></extra>` +
+		`</drawing>`
+	d := NewDecoder(strings.NewReader(input))
+	d.RegisterType(xml.Name{Space: "urn:shapes", Local: "square"}, &Square{})
+	d.RegisterType(xml.Name{Local: "square"}, &Square{})
+	var v Drawing
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	want := Drawing{
+		Shapes: []Shape{Circle{R: 1}, &Square{Side: 2}, Circle{R: 3}},
+		Main:   &Square{Side: 4},
+		Extra: &Node{
+			XMLName: xml.Name{Local: "extra"},
+			Attrs:   []xml.Attr{{Name: xml.Name{Local: "id"}, Value: "7"}},
+			Content: "text",
+			Nodes:   []Node{{XMLName: xml.Name{Local: "child"}, Content: "x"}},
+		},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("have %#v\nwant %#v", v, want)
+	}
+
+	out, err := Marshal(v.Extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), `<extra id="7">text<child>x</child></extra>`; got != want {
+		t.Errorf("Marshal(Node) = %s, want %s", got, want)
+	}
+
+	// A registered type must fit the interface.
+	d = NewDecoder(strings.NewReader(`<drawing><triangle/></drawing>`))
+	d.RegisterType(xml.Name{Local: "triangle"}, Node{})
+	err = d.Decode(&v)
+	if err == nil || !strings.Contains(err.Error(), "does not implement") {
+		t.Errorf("got error %v, want error about the interface", err)
+	}
+}
+
+type Animal interface {
+	Sound() string
+}
+
+type Dog struct {
+	Name string `xml:"name"`
+}
+
+func (d *Dog) Sound() string { return "woof" }
+
+type Cat struct {
+	Name string `xml:"name"`
+}
+
+func (c Cat) Sound() string { return "meow" }
+
+type Zoo struct {
+	XMLName xml.Name `xml:"zoo"`
+	Animals []Animal `xml:"animal"`
+}
+
+func init() {
+	RegisterXSIType(xml.Name{Space: "urn:zoo", Local: "Dog"}, &Dog{})
+}
+
+func TestXSIType(t *testing.T) {
+	const input = `<zoo xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:z="urn:zoo">` +
+		`<animal xsi:type="z:Dog"><name>Rex</name></animal>` +
+		`<animal xmlns="urn:zoo" xsi:type="Cat"><name>Tom</name></animal>` +
+		`<animal xsi:type="z:Unknown"><name>?</name></animal>` +
+		`</zoo>`
+	d := NewDecoder(strings.NewReader(input))
+	d.RegisterXSIType(xml.Name{Space: "urn:zoo", Local: "Cat"}, Cat{})
+	d.Unconsumed = UnconsumedReport{}
+	var v Zoo
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	want := []Animal{&Dog{Name: "Rex"}, Cat{Name: "Tom"}}
+	if !reflect.DeepEqual(v.Animals, want) {
+		t.Errorf("Animals = %#v, want %#v", v.Animals, want)
+	}
+	if _, ok := d.Unconsumed["/zoo/animal/@type"]; ok {
+		t.Errorf("xsi:type reported as unconsumed: %v", d.Unconsumed)
+	}
+
+	var b strings.Builder
+	enc := NewEncoder(&b)
+	enc.RegisterXSIType(xml.Name{Space: "urn:zoo", Local: "Cat"}, Cat{})
+	if err := enc.Encode(&Zoo{Animals: want}); err != nil {
+		t.Fatal(err)
+	}
+	const out = `<zoo>` +
+		`<animal xmlns:_="urn:zoo" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="_:Dog"><name>Rex</name></animal>` +
+		`<animal xmlns:_="urn:zoo" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="_:Cat"><name>Tom</name></animal>` +
+		`</zoo>`
+	if b.String() != out {
+		t.Errorf("Encode:\nhave %s\nwant %s", b.String(), out)
+	}
+
+	// The encoded document decodes to the same values.
+	d = NewDecoder(strings.NewReader(b.String()))
+	d.RegisterXSIType(xml.Name{Space: "urn:zoo", Local: "Cat"}, Cat{})
+	v = Zoo{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.Animals, want) {
+		t.Errorf("round trip: Animals = %#v, want %#v", v.Animals, want)
+	}
+}
+
+type NilLine struct {
+	Price *string `xml:"price,nillable"`
+}
+
+type NilOrder struct {
+	XMLName xml.Name  `xml:"order"`
+	Note    *string   `xml:"note,nillable"`
+	Code    *string   `xml:"code"`
+	Lines   []NilLine `xml:"line"`
+}
+
+func TestXSINil(t *testing.T) {
+	empty, ten := "", "10"
+	v := NilOrder{
+		Code:  &empty,
+		Lines: []NilLine{{Price: &ten}, {}},
+	}
+	out, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `<order xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<note xsi:nil="true"></note><code></code>` +
+		`<line><price>10</price></line><line><price xsi:nil="true"></price></line>` +
+		`</order>`
+	if string(out) != want {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, want)
+	}
+
+	var got NilOrder
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Note != nil || got.Code == nil || *got.Code != "" {
+		t.Errorf("Unmarshal: Note = %v, Code = %v; want nil and empty string", got.Note, got.Code)
+	}
+	if len(got.Lines) != 2 || got.Lines[0].Price == nil || *got.Lines[0].Price != "10" || got.Lines[1].Price != nil {
+		t.Errorf("Unmarshal: Lines = %+v", got.Lines)
+	}
+
+	// An explicit nil element clears a value set before.
+	got.Note = &ten
+	if err := Unmarshal([]byte(`<order><note xmlns:i="http://www.w3.org/2001/XMLSchema-instance" i:nil="1"/></order>`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Note != nil {
+		t.Errorf("Note = %q, want nil", *got.Note)
+	}
+
+	var bad struct {
+		A *string `xml:"a,attr,nillable"`
+	}
+	if _, err := Marshal(&bad); err == nil {
+		t.Errorf("nillable attribute: want error")
+	}
+}
+
+type Pet struct {
+	Animal
+}
+
+type Shelter struct {
+	XMLName xml.Name `xml:"shelter"`
+	Pets    []Pet    `xml:"pet"`
+}
+
+func TestXSITypeBaseField(t *testing.T) {
+	const data = `<shelter>` +
+		`<pet xmlns:_="urn:zoo" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="_:Dog"><name>Rex</name></pet>` +
+		`<pet></pet>` +
+		`</shelter>`
+	var v Shelter
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	want := []Pet{{&Dog{Name: "Rex"}}, {}}
+	if !reflect.DeepEqual(v.Pets, want) {
+		t.Errorf("Pets = %#v, want %#v", v.Pets, want)
+	}
+
+	out, err := Marshal(&Shelter{Pets: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, data)
+	}
+}
@@ -0,0 +1,230 @@
+package xmlutils
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A step of a tag path may carry a predicate selecting elements among
+// those of the same name, by the value of an attribute as in
+//
+//	Timeout int `xml:"param[@name='timeout']>value"`
+//
+// or by position among its siblings of that name, counting from one,
+// as in item[1]. Unmarshal routes only the selected elements into the
+// field. Marshal adds the attribute of an attribute predicate to the
+// element it writes; elements are written in field order, so position
+// predicates are only checked by Unmarshal. Predicate values cannot
+// contain the characters , > | [ ] or space, which separate tag parts,
+// and a step with a predicate cannot have aliases. The attribute of a
+// predicate is matched by its local name and cannot have a prefix.
+
+// A predicate selects elements by attribute value or by position.
+type predicate struct {
+	attr  string
+	value string
+	pos   int
+}
+
+// splitPredicate splits a step of a tag path into its name and the
+// source of its predicate, if any.
+func splitPredicate(step string) (name, pred string) {
+	if i := strings.IndexByte(step, '['); i >= 0 {
+		return step[:i], step[i:]
+	}
+	return step, ""
+}
+
+// parsePredicate parses the predicate source s, such as [@name='x']
+// or [2].
+func parsePredicate(s string) (*predicate, error) {
+	if len(s) < 3 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil, fmt.Errorf("invalid predicate %q", s)
+	}
+	body := s[1 : len(s)-1]
+	if body[0] != '@' {
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid position in predicate %q", s)
+		}
+		return &predicate{pos: n}, nil
+	}
+	i := strings.IndexByte(body, '=')
+	if i < 0 {
+		return nil, fmt.Errorf("missing value in predicate %q", s)
+	}
+	attr, value := body[1:i], body[i+1:]
+	if !isNameString(attr) {
+		return nil, fmt.Errorf("invalid attribute name in predicate %q", s)
+	}
+	if strings.Contains(attr, ":") {
+		return nil, fmt.Errorf("name space prefix in predicate %q; predicates match local attribute names", s)
+	}
+	if len(value) < 2 || value[0] != value[len(value)-1] || value[0] != '\'' && value[0] != '"' {
+		return nil, fmt.Errorf("unquoted value in predicate %q", s)
+	}
+	return &predicate{attr: attr, value: value[1 : len(value)-1]}, nil
+}
+
+// checkPredicates checks the brackets of the predicates in tag. The
+// tag is split at its first space and at its commas before predicates
+// are parsed, so those characters cannot occur inside them.
+func checkPredicates(tag string) error {
+	if i := strings.IndexByte(tag, ' '); i >= 0 && !strings.Contains(tag[:i], ",") {
+		if strings.Count(tag[:i], "[") > strings.Count(tag[:i], "]") {
+			return fmt.Errorf("space in predicate of %q", tag)
+		}
+		tag = tag[i+1:]
+	}
+	depth, alias := 0, false
+	for i := 0; i < len(tag); i++ {
+		switch c := tag[i]; {
+		case depth == 0 && c == ',':
+			// The options follow.
+			return nil
+		case c == '[':
+			if depth > 0 {
+				return fmt.Errorf("nested '[' in predicate of %q", tag)
+			}
+			if alias {
+				return fmt.Errorf("predicate combined with alias in %q", tag)
+			}
+			depth++
+		case c == ']':
+			if depth == 0 {
+				return fmt.Errorf("unbalanced ']' in %q", tag)
+			}
+			depth--
+			if i+1 < len(tag) && strings.IndexByte(">@,", tag[i+1]) < 0 {
+				if tag[i+1] == '|' {
+					return fmt.Errorf("predicate combined with alias in %q", tag)
+				}
+				return fmt.Errorf("unexpected %q after predicate in %q", tag[i+1], tag)
+			}
+		case depth > 0:
+			if strings.IndexByte(" ,>|", c) >= 0 {
+				return fmt.Errorf("%q in predicate of %q", c, tag)
+			}
+		case c == '|':
+			alias = true
+		case c == '>':
+			alias = false
+		}
+	}
+	if depth > 0 {
+		return fmt.Errorf("unbalanced '[' in %q", tag)
+	}
+	return nil
+}
+
+// pathPredicates parses the predicates of the steps of a tag path.
+// It returns nil if there are none.
+func pathPredicates(steps []string) ([]*predicate, error) {
+	var preds []*predicate
+	for i, step := range steps {
+		_, src := splitPredicate(step)
+		if src == "" {
+			continue
+		}
+		pred, err := parsePredicate(src)
+		if err != nil {
+			return nil, err
+		}
+		if preds == nil {
+			preds = make([]*predicate, len(steps))
+		}
+		preds[i] = pred
+	}
+	return preds, nil
+}
+
+// matches reports whether start, the n-th element of its name among
+// its siblings, is selected by pred.
+func (pred *predicate) matches(start *xml.StartElement, n int) bool {
+	if pred.pos > 0 {
+		return n == pred.pos
+	}
+	for _, a := range start.Attr {
+		if a.Name.Local == pred.attr {
+			return a.Value == pred.value
+		}
+	}
+	return false
+}
+
+// attrFor returns the attribute that selects an element for pred, if any.
+func (pred *predicate) attrFor() (xml.Attr, bool) {
+	if pred == nil || pred.attr == "" {
+		return xml.Attr{}, false
+	}
+	return xml.Attr{Name: xml.Name{Local: pred.attr}, Value: pred.value}, true
+}
+
+// selects reports whether start, the n-th element of its name among
+// its siblings, passes the predicate of step i of the path of finfo,
+// where step len(finfo.parents) is the element of finfo itself.
+func (finfo *fieldInfo) selects(i int, start *xml.StartElement, n int) bool {
+	if i >= len(finfo.preds) || finfo.preds[i] == nil {
+		return true
+	}
+	return finfo.preds[i].matches(start, n)
+}
+
+// addPredicateAttr adds the attribute selecting the element of finfo,
+// if its predicate has one, to start.
+func (finfo *fieldInfo) addPredicateAttr(start *xml.StartElement) {
+	if len(finfo.preds) <= len(finfo.parents) {
+		return
+	}
+	if attr, ok := finfo.preds[len(finfo.parents)].attrFor(); ok {
+		start.Attr = append(start.Attr, attr)
+	}
+}
+
+// hasStep reports whether step, a step of another tag path, names the
+// element of finfo with the same predicate.
+func (finfo *fieldInfo) hasStep(step string) bool {
+	name, pred := splitPredicate(step)
+	return pred == finfo.namePred && finfo.hasName(name)
+}
+
+// stepMatches reports whether start, the n-th element of its name among
+// its siblings, matches the parent i of finfo.
+func (d *Decoder) stepMatches(finfo *fieldInfo, i int, start *xml.StartElement, n int) bool {
+	name, _ := splitPredicate(finfo.parents[i])
+	return d.nameMatches(name, start.Name.Local) && finfo.selects(i, start, n)
+}
+
+// A pathStep is an element on the path walked by unmarshalPath, with
+// its position among its siblings of that name.
+type pathStep struct {
+	start *xml.StartElement
+	n     int
+}
+
+// onPath reports whether the elements of path match the first
+// len(path) parents of finfo, predicates included.
+func (d *Decoder) onPath(finfo *fieldInfo, path []pathStep) bool {
+	for i, step := range path {
+		if !d.stepMatches(finfo, i, step.start, step.n) {
+			return false
+		}
+	}
+	return true
+}
+
+// siblings counts the child elements of an element by name, to give
+// their positions for predicates. The zero value counts nothing, for
+// types without position predicates.
+type siblings map[string]int
+
+// next returns the position of start among its siblings of that name.
+func (s siblings) next(start *xml.StartElement) int {
+	if s == nil {
+		return 0
+	}
+	s[start.Name.Local]++
+	return s[start.Name.Local]
+}
@@ -0,0 +1,126 @@
+package xmlutils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type SelectConfig struct {
+	XMLName struct{} `xml:"config"`
+	Timeout int      `xml:"param[@name='timeout']>value"`
+	Unit    string   `xml:"param[@name='timeout']@unit"`
+	Retries int      `xml:"param[@name='retries']>value"`
+	Mode    string   `xml:"option[@key='mode']"`
+}
+
+func TestSelectByAttribute(t *testing.T) {
+	const data = `<config>` +
+		`<param name="timeout" unit="s"><value>30</value></param>` +
+		`<param name="retries"><value>3</value></param>` +
+		`<option key="mode">fast</option>` +
+		`</config>`
+	want := SelectConfig{Timeout: 30, Retries: 3, Mode: "fast", Unit: "s"}
+
+	var v SelectConfig
+	input := `<config><param name="retries"><value>3</value></param><param name="other"><value>9</value></param>` +
+		`<option key="level">2</option><option key="mode">fast</option>` +
+		`<param unit="s" name="timeout"><value>30</value></param></config>`
+	if err := Unmarshal([]byte(input), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v != want {
+		t.Errorf("Unmarshal:\nhave %+v\nwant %+v", v, want)
+	}
+
+	out, err := Marshal(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != data {
+		t.Errorf("Marshal:\nhave %s\nwant %s", out, data)
+	}
+}
+
+type SelectList struct {
+	XMLName struct{} `xml:"list"`
+	First   string   `xml:"item[1]"`
+	Second  string   `xml:"item[2]>name"`
+	Last    []string `xml:"tail>v[2]"`
+}
+
+func TestSelectByPosition(t *testing.T) {
+	input := `<list><item>a</item><other/><item><name>b</name></item><item>c</item>` +
+		`<tail><v>x</v><v>y</v></tail><tail><v>z</v></tail></list>`
+	want := SelectList{First: "a", Second: "b", Last: []string{"y"}}
+	var v SelectList
+	if err := Unmarshal([]byte(input), &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Unmarshal:\nhave %+v\nwant %+v", v, want)
+	}
+}
+
+func TestSelectSharedPrefix(t *testing.T) {
+	var v struct {
+		B string `xml:"p[@a='1']>v"`
+		C string `xml:"p>w"`
+		D string `xml:"p[@a='2']>w"`
+	}
+	if err := Unmarshal([]byte(`<cfg><p a="1"><v>V</v><w>W</w></p></cfg>`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.B != "V" || v.C != "W" || v.D != "" {
+		t.Errorf("have %+v, want B V, C W and D empty", v)
+	}
+}
+
+func TestSelectInvalid(t *testing.T) {
+	for _, v := range []interface{}{
+		&struct {
+			A string `xml:"a[0]"`
+		}{},
+		&struct {
+			A string `xml:"a[@b=c]"`
+		}{},
+		&struct {
+			A string `xml:"a[@b='c'],attr"`
+		}{},
+		&struct {
+			A string `xml:"a[@b='c d']"`
+		}{},
+		&struct {
+			A string `xml:"a[@b='c,d']"`
+		}{},
+		&struct {
+			A string `xml:"a[1"`
+		}{},
+		&struct {
+			A string `xml:"p[@x:k='v']>q"`
+		}{},
+		&struct {
+			A string `xml:"a1]>b"`
+		}{},
+	} {
+		if err := Unmarshal([]byte(`<x/>`), v); err == nil {
+			t.Errorf("Unmarshal into %T: want error", v)
+		}
+	}
+}
+
+func TestSelectAlias(t *testing.T) {
+	for _, v := range []interface{}{
+		&struct {
+			A string `xml:"i[@a='1']|j"`
+		}{},
+		&struct {
+			A string `xml:"i|j[@a='1']"`
+		}{},
+	} {
+		_, err := Marshal(v)
+		if err == nil || !strings.Contains(err.Error(), "predicate combined with alias") {
+			t.Errorf("Marshal %T: got error %v", v, err)
+		}
+	}
+}
@@ -23,19 +23,35 @@ type XMLTag struct {
 type Tag struct {
 	Prefix string
 	Value string
+
+	// Aliases это альтернативные имена из записи Quantity|Qty,
+	// Unmarshal принимает любое из них, Marshal использует основное
+	Aliases []Tag
 }
 
 func (t Tag) String() string {
-	if t.Prefix == "" {
-		return t.Value
+	s := t.Value
+	if t.Prefix != "" {
+		s = t.Prefix+":"+t.Value
+	}
+	for _, alias := range t.Aliases {
+		s = s+"|"+alias.String()
 	}
-	return t.Prefix+":"+t.Value
+	return s
 }
 
 func (t *XMLTag) DeleteNSPrefix() {
 	for n, tag := range t.Tags {
 		tag := tag
 		tag.Prefix = ""
+		if tag.Aliases != nil {
+			aliases := make([]Tag, len(tag.Aliases))
+			for i, alias := range tag.Aliases {
+				alias.Prefix = ""
+				aliases[i] = alias
+			}
+			tag.Aliases = aliases
+		}
 		t.Tags[n] = tag
 	}
 }
@@ -56,14 +72,28 @@ func (t *XMLTag) String() string {
 	return s
 }
 
+// Option возвращает значение опции вида key=value, например default=RUB
+// или default='a,b' без кавычек, и признак её наличия в тэге
+func (t *XMLTag) Option(key string) (string, bool) {
+	for _, flag := range t.Flags {
+		if flag == key {
+			return "", true
+		}
+		if strings.HasPrefix(flag, key+"=") {
+			return unquoteOption(flag[len(key)+1:]), true
+		}
+	}
+	return "", false
+}
+
 func ParseXMLTag(s string) (*XMLTag, error) {
 	var err error
 	t := &XMLTag{}
 
-	if i := strings.Index(s, " "); i >= 0 {
+	if i := strings.Index(s, " "); i >= 0 && !strings.Contains(s[:i], ",") {
 		t.Namespace, s = s[:i], s[i+1:]
 	}
-	tokens := strings.Split(s, ",")
+	tokens := splitTag(s)
 	switch len(tokens) {
 	case 0:
 		return nil, errors.New("empty tag")
@@ -83,29 +113,71 @@ func ParseXMLTag(s string) (*XMLTag, error) {
 }
 
 func ParseTag(s string) ([]Tag, error) {
-	tokens := strings.Split(s, ">")
+	tokens := splitOutsideBrackets(s, '>')
 	t := make([]Tag, len(tokens))
 	for n, token := range tokens {
-		tag := strings.Split(token, ":")
-		switch len(tag) {
-		case 0:
-			return t, errors.New("empty tag section")
-		case 1:
-			t[n] = Tag{
-				Value: token,
+		names := splitOutsideBrackets(token, '|')
+		for i, name := range names {
+			tag, err := parseTagName(name)
+			if err != nil {
+				return t, err
 			}
-		case 2:
-			t[n] = Tag{
-				Prefix: tag[0],
-				Value:  tag[1],
+			if i == 0 {
+				t[n] = tag
+				continue
 			}
-		default:
-			return t, errors.New("many tag prefix")
+			t[n].Aliases = append(t[n].Aliases, tag)
 		}
 	}
 	return t, nil
 }
 
+// splitOutsideBrackets делит s по sep, пропуская разделители внутри
+// предикатов вида [@name='a>b']
+func splitOutsideBrackets(s string, sep byte) []string {
+	var tokens []string
+	depth, last := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case sep:
+			if depth == 0 {
+				tokens = append(tokens, s[last:i])
+				last = i+1
+			}
+		}
+	}
+	return append(tokens, s[last:])
+}
+
+// parseTagName разбирает одно имя тэга вида prefix:value,
+// предикат вида [@name='x'] остаётся в Value целиком
+func parseTagName(s string) (Tag, error) {
+	name, pred := s, ""
+	if i := strings.Index(s, "["); i >= 0 {
+		name, pred = s[:i], s[i:]
+	}
+	tag := strings.Split(name, ":")
+	switch len(tag) {
+	case 0:
+		return Tag{}, errors.New("empty tag section")
+	case 1:
+		return Tag{
+			Value: s,
+		}, nil
+	case 2:
+		return Tag{
+			Prefix: tag[0],
+			Value:  tag[1]+pred,
+		}, nil
+	default:
+		return Tag{}, errors.New("many tag prefix")
+	}
+}
+
 // DeleteNSPrefix удаляет префикс xml тега, оставляя все остальные элементы тега
 func DeleteNSPrefix(tag string) (string, error) {
 	t, err := ParseXMLTag(tag)
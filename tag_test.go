@@ -66,3 +66,63 @@ func TestTag(t *testing.T) {
 		)
 	})
 }
+
+func TestDeleteNSPrefixOptionWithSpace(t *testing.T) {
+	Convey("Пробел в значении опции не считается разделителем пространства имён", t, func() {
+		tag, err := xmlutils.DeleteNSPrefix("st:date,timeformat=02.01.2006 15:04")
+		So(err, ShouldBeNil)
+		So(tag, ShouldEqual, "date,timeformat=02.01.2006 15:04")
+
+		tag, err = xmlutils.DeleteNSPrefix("http://localhost st:date,attr")
+		So(err, ShouldBeNil)
+		So(tag, ShouldEqual, "http://localhost date,attr")
+	})
+}
+
+func TestXMLTagOption(t *testing.T) {
+	Convey("Проверяем чтение опций вида key=value", t, func() {
+		tag, err := xmlutils.ParseXMLTag("currency,attr,default=RUB")
+		So(err, ShouldBeNil)
+		value, ok := tag.Option("default")
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, "RUB")
+		_, ok = tag.Option("attr")
+		So(ok, ShouldBeTrue)
+		_, ok = tag.Option("omitempty")
+		So(ok, ShouldBeFalse)
+
+		tag, err = xmlutils.ParseXMLTag("a:b,attr,default='x,y'")
+		So(err, ShouldBeNil)
+		So(tag.Flags, ShouldResemble, []string{"attr", "default='x,y'"})
+		value, ok = tag.Option("default")
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, "x,y")
+	})
+}
+
+func TestParseTagAliases(t *testing.T) {
+	Convey("Проверяем альтернативные имена вида Quantity|Qty", t, func() {
+		tags, err := xmlutils.ParseTag("st:line>st:Quantity|st:Qty")
+		So(err, ShouldBeNil)
+		So(len(tags), ShouldEqual, 2)
+		So(tags[1].String(), ShouldEqual, "st:Quantity|st:Qty")
+		So(tags[1].Aliases, ShouldResemble, []xmlutils.Tag{{Prefix: "st", Value: "Qty"}})
+
+		tag, err := xmlutils.DeleteNSPrefix("st:line>st:Quantity|st:Qty,omitempty")
+		So(err, ShouldBeNil)
+		So(tag, ShouldEqual, "line>Quantity|Qty,omitempty")
+	})
+}
+
+func TestDeleteNSPrefixPredicate(t *testing.T) {
+	Convey("Двоеточие и разделители внутри предиката не разбирают имя", t, func() {
+		tag, err := xmlutils.DeleteNSPrefix("st:p[@name='urn:x']>st:q,attr")
+		So(err, ShouldBeNil)
+		So(tag, ShouldEqual, "p[@name='urn:x']>q,attr")
+
+		tags, err := xmlutils.ParseTag("st:a[@k='x|y']>b")
+		So(err, ShouldBeNil)
+		So(len(tags), ShouldEqual, 2)
+		So(tags[0], ShouldResemble, xmlutils.Tag{Prefix: "st", Value: "a[@k='x|y']"})
+	})
+}
@@ -8,23 +8,60 @@ import (
 	"encoding/xml"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // typeInfo holds details for the xml representation of a type.
 type typeInfo struct {
-	xmlname *fieldInfo
-	fields  []fieldInfo
+	xmlname   *fieldInfo
+	base      *fieldInfo // embedded interface receiving xsi:type values
+	fields    []fieldInfo
+	defaults  bool // some field has a default value
+	positions bool // some field path selects elements by position
 }
 
 // fieldInfo holds details for the xml representation of a single field.
 type fieldInfo struct {
 	idx     []int
 	name    string
+	aliases []string // alternate names accepted by Unmarshal
 	xmlns   string
 	flags   fieldFlags
 	parents []string
+
+	// timeFormat is the layout of a time.Time field given by the
+	// timeformat tag option, or "unix" or "unixmilli" for epoch
+	// timestamps. timeLoc is the location given by timezone.
+	timeFormat string
+	timeLoc    *time.Location
+
+	// mapKey is the attribute holding the key of map entries,
+	// given by the key tag option.
+	mapKey string
+
+	// defValue is the value given by the default tag option,
+	// if hasDefault is set.
+	defValue   string
+	hasDefault bool
+
+	// fixed is the value given by the fixed tag option, if hasFixed
+	// is set. blank marks a fixed field named _, which holds no value.
+	fixed    string
+	hasFixed bool
+	blank    bool
+
+	// enum lists the values allowed by the enum tag option.
+	enum []string
+
+	// preds holds the predicates of the steps of the path of the
+	// field, the parents followed by the element itself, or nil if
+	// there are none. namePred is the source of the last one.
+	preds    []*predicate
+	namePred string
 }
 
 type fieldFlags int
@@ -39,6 +76,7 @@ const (
 	fAny
 
 	fOmitEmpty
+	fNillable
 
 	fMode = fElement | fAttr | fCDATA | fCharData | fInnerXml | fComment | fAny
 
@@ -48,7 +86,45 @@ const (
 var marshalTinfoMap sync.Map // map[reflect.Type]*typeInfo
 var unmarshalTinfoMap sync.Map // map[reflect.Type]*typeInfo
 
+// tagKey holds the struct tag key read in preference to "xml".
+var tagKey atomic.Value
+
+func init() {
+	tagKey.Store("xmlutils")
+}
+
+// SetTagKey sets the struct tag key whose value, when present, is used
+// instead of the xml tag of a field. It is "xmlutils" by default, so
+// that a field can carry an encoding/xml compatible tag along with one
+// using the features of this package:
+//
+//	Price float64 `xml:"price" xmlutils:"p:price,attr,default=0"`
+//
+// An empty key makes only xml tags count. SetTagKey is meant to be
+// called during initialization, before any value is encoded or decoded.
+func SetTagKey(key string) {
+	tagKey.Store(key)
+	for _, m := range []*sync.Map{&marshalTinfoMap, &unmarshalTinfoMap, &xsiNeeded} {
+		m.Range(func(k, _ interface{}) bool {
+			m.Delete(k)
+			return true
+		})
+	}
+}
+
+// fieldTag returns the tag of f under the configured tag key if it has
+// one, and its xml tag otherwise.
+func fieldTag(f *reflect.StructField) string {
+	if key := tagKey.Load().(string); key != "" {
+		if tag, ok := f.Tag.Lookup(key); ok {
+			return tag
+		}
+	}
+	return f.Tag.Get("xml")
+}
+
 var nameType = reflect.TypeOf(xml.Name{})
+var timeType = reflect.TypeOf(time.Time{})
 
 type Utils struct {
 	// Marshal
@@ -75,7 +151,7 @@ func (u *Utils) getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 		n := typ.NumField()
 		for i := 0; i < n; i++ {
 			f := typ.Field(i)
-			if (f.PkgPath != "" && !f.Anonymous) || f.Tag.Get("xml") == "-" {
+			if (f.PkgPath != "" && !f.Anonymous && !isFixedBlank(&f)) || fieldTag(&f) == "-" {
 				continue // Private field
 			}
 
@@ -93,6 +169,9 @@ func (u *Utils) getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 					if tinfo.xmlname == nil {
 						tinfo.xmlname = inner.xmlname
 					}
+					if tinfo.base == nil && inner.base != nil {
+						tinfo.base = &fieldInfo{idx: append([]int{i}, inner.base.idx...)}
+					}
 					for _, finfo := range inner.fields {
 						finfo.idx = append([]int{i}, finfo.idx...)
 						if err := addFieldInfo(typ, tinfo, &finfo); err != nil {
@@ -101,6 +180,12 @@ func (u *Utils) getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 					}
 					continue
 				}
+				if t.Kind() == reflect.Interface && f.PkgPath == "" && fieldTag(&f) == "" {
+					// An embedded interface is the base field
+					// for values chosen by xsi:type.
+					tinfo.base = &fieldInfo{idx: f.Index}
+					continue
+				}
 			}
 
 			finfo, err := u.structFieldInfo(typ, &f)
@@ -120,6 +205,17 @@ func (u *Utils) getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 		}
 	}
 
+	for i := range tinfo.fields {
+		if tinfo.fields[i].hasDefault {
+			tinfo.defaults = true
+		}
+		for _, pred := range tinfo.fields[i].preds {
+			if pred != nil && pred.pos > 0 {
+				tinfo.positions = true
+			}
+		}
+	}
+
 	var ti interface{}
 	if u.Marshal {
 		ti, _ = marshalTinfoMap.LoadOrStore(typ, tinfo)
@@ -129,12 +225,78 @@ func (u *Utils) getTypeInfo(typ reflect.Type) (*typeInfo, error) {
 	return ti.(*typeInfo), nil
 }
 
+// expandAttrPath rewrites the short form a>b@c of a tag as a>b>c,attr,
+// naming the attribute c of the element b nested in a.
+func expandAttrPath(tag string) string {
+	ns, name, opts := "", tag, ""
+	if i := strings.Index(name, ","); i >= 0 {
+		name, opts = name[:i], name[i:]
+	}
+	if i := strings.Index(name, " "); i >= 0 {
+		ns, name = name[:i+1], name[i+1:]
+	}
+	// An @ inside a predicate such as [@name='x'] does not count.
+	i, depth := -1, 0
+	for j := 0; j < len(name) && i < 0; j++ {
+		switch name[j] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '@':
+			if depth == 0 {
+				i = j
+			}
+		}
+	}
+	if i < 0 {
+		return tag
+	}
+	if i == 0 {
+		return ns + name[1:] + ",attr" + opts
+	}
+	return ns + name[:i] + ">" + name[i+1:] + ",attr" + opts
+}
+
+// splitTag splits tag at its commas, except for those inside an
+// option value in single quotes. Layouts with spaces are better given
+// under the xmlutils tag key, since go vet reports spaces in xml tags.
+func splitTag(tag string) []string {
+	var tokens []string
+	options, quoted := false, false
+	last := 0
+	for i := 0; i < len(tag); i++ {
+		switch c := tag[i]; {
+		case quoted:
+			quoted = c != '\'' || i+1 < len(tag) && tag[i+1] != ','
+		case c == '\'' && options && tag[i-1] == '=':
+			quoted = true
+		case c == ',':
+			tokens = append(tokens, tag[last:i])
+			last, options = i+1, true
+		}
+	}
+	return append(tokens, tag[last:])
+}
+
+// unquoteOption removes the single quotes around an option value.
+func unquoteOption(value string) string {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
 // structFieldInfo builds and returns a fieldInfo for f.
 func (u *Utils) structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, error) {
-	finfo := &fieldInfo{idx: f.Index}
+	finfo := &fieldInfo{idx: f.Index, blank: f.Name == "_"}
+
+	if err := checkPredicates(fieldTag(f)); err != nil {
+		return nil, fmt.Errorf("xml: %v in field %s of type %s", err, f.Name, typ)
+	}
 
 	// Split the tag from the xml namespace if necessary.
-	tag := f.Tag.Get("xml")
+	tag := expandAttrPath(fieldTag(f))
 	if !u.Marshal {
 		var err error
 		tag, err = DeleteNSPrefix(tag)
@@ -143,17 +305,23 @@ func (u *Utils) structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fiel
 		}
 	}
 
-	if i := strings.Index(tag, " "); i >= 0 {
+	if i := strings.Index(tag, " "); i >= 0 && !strings.Contains(tag[:i], ",") {
 		finfo.xmlns, tag = tag[:i], tag[i+1:]
 	}
 
 	// Parse flags.
-	tokens := strings.Split(tag, ",")
+	tokens := splitTag(tag)
 	if len(tokens) == 1 {
 		finfo.flags = fElement
 	} else {
 		tag = tokens[0]
 		for _, flag := range tokens[1:] {
+			// Options may carry a value as in timeformat=2006-01-02,
+			// quoted if it has commas as in timeformat='Mon, 02 Jan 2006'.
+			value := ""
+			if i := strings.Index(flag, "="); i >= 0 {
+				flag, value = flag[:i], unquoteOption(flag[i+1:])
+			}
 			switch flag {
 			case "attr":
 				finfo.flags |= fAttr
@@ -169,8 +337,39 @@ func (u *Utils) structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fiel
 				finfo.flags |= fAny
 			case "omitempty":
 				finfo.flags |= fOmitEmpty
+			case "nillable":
+				finfo.flags |= fNillable
+			case "default":
+				finfo.defValue, finfo.hasDefault = value, true
+			case "fixed":
+				finfo.fixed, finfo.hasFixed = value, true
+			case "enum":
+				finfo.enum = strings.Split(value, "|")
+			case "key":
+				finfo.mapKey = value
+				if value == "" {
+					finfo.mapKey = "key"
+				}
+			case "timeformat":
+				finfo.timeFormat = value
+			case "unix", "unixmilli":
+				finfo.timeFormat = flag
+			case "timezone":
+				loc, err := loadLocation(value)
+				if err != nil {
+					return nil, fmt.Errorf("xml: invalid timezone in field %s of type %s: %v",
+						f.Name, typ, err)
+				}
+				finfo.timeLoc = loc
 			}
 		}
+		if finfo.timeLoc != nil && finfo.timeFormat == "" {
+			finfo.timeFormat = time.RFC3339
+		}
+		if finfo.timeFormat != "" && !isTimeType(f.Type) {
+			return nil, fmt.Errorf("xml: time option in field %s of type %s requires time.Time, have %s",
+				f.Name, typ, f.Type)
+		}
 
 		// Validate the flags used.
 		valid := true
@@ -178,7 +377,9 @@ func (u *Utils) structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fiel
 		case 0:
 			finfo.flags |= fElement
 		case fAttr, fCDATA, fCharData, fInnerXml, fComment, fAny, fAny | fAttr:
-			if f.Name == xmlName || tag != "" && mode != fAttr {
+			// The text modes accept the path of the element
+			// holding the text.
+			if f.Name == xmlName || tag != "" && mode != fAttr && mode&(fCDATA|fCharData|fInnerXml) == 0 {
 				valid = false
 			}
 		default:
@@ -191,23 +392,50 @@ func (u *Utils) structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fiel
 		if finfo.flags&fOmitEmpty != 0 && finfo.flags&(fElement|fAttr) == 0 {
 			valid = false
 		}
+		if finfo.flags&fNillable != 0 && finfo.flags&fElement == 0 {
+			valid = false
+		}
 		if !valid {
 			return nil, fmt.Errorf("xml: invalid tag in field %s of type %s: %q",
-				f.Name, typ, f.Tag.Get("xml"))
+				f.Name, typ, fieldTag(f))
+		}
+		if finfo.hasDefault && finfo.flags&(fElement|fAttr) == 0 {
+			return nil, fmt.Errorf("xml: default option in field %s of type %s requires an element or attribute",
+				f.Name, typ)
+		}
+		if finfo.hasFixed && finfo.flags&(fElement|fAttr) == 0 {
+			return nil, fmt.Errorf("xml: fixed option in field %s of type %s requires an element or attribute",
+				f.Name, typ)
+		}
+		if finfo.enum != nil && finfo.flags&(fElement|fAttr) == 0 {
+			return nil, fmt.Errorf("xml: enum option in field %s of type %s requires an element or attribute",
+				f.Name, typ)
+		}
+		if finfo.hasFixed && !finfo.hasDefault {
+			// An absent fixed value takes the fixed value.
+			finfo.defValue, finfo.hasDefault = finfo.fixed, true
+		}
+		if finfo.mapKey != "" && (!isStringMap(f.Type) || finfo.flags&fElement == 0) {
+			return nil, fmt.Errorf("xml: key option in field %s of type %s requires an element of map type with string keys, have %s",
+				f.Name, typ, f.Type)
 		}
 	}
 
 	// Use of xmlns without a name is not allowed.
 	if finfo.xmlns != "" && tag == "" {
 		return nil, fmt.Errorf("xml: namespace without name in field %s of type %s: %q",
-			f.Name, typ, f.Tag.Get("xml"))
+			f.Name, typ, fieldTag(f))
 	}
 
 	if f.Name == xmlName {
 		// The XMLName field records the XML element name. Don't
 		// process it as usual because its name should default to
 		// empty rather than to the field name.
-		finfo.name = tag
+		names := strings.Split(tag, "|")
+		finfo.name = names[0]
+		if len(names) > 1 {
+			finfo.aliases = names[1:]
+		}
 		return finfo, nil
 	}
 
@@ -231,9 +459,51 @@ func (u *Utils) structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fiel
 	if parents[len(parents)-1] == "" {
 		return nil, fmt.Errorf("xml: trailing '>' in field %s of type %s", f.Name, typ)
 	}
-	finfo.name = parents[len(parents)-1]
+	if finfo.flags&(fCDATA|fCharData|fInnerXml) != 0 {
+		for _, parent := range parents {
+			if strings.Contains(parent, "|") {
+				return nil, fmt.Errorf("xml: alias in %q of field %s of type %s; aliases are only allowed for the last name",
+					parent, f.Name, typ)
+			}
+		}
+		finfo.parents = parents
+		preds, err := pathPredicates(parents)
+		if err != nil {
+			return nil, fmt.Errorf("xml: %v in field %s of type %s", err, f.Name, typ)
+		}
+		finfo.preds = preds
+		return finfo, nil
+	}
+	last, namePred := splitPredicate(parents[len(parents)-1])
+	if namePred != "" && finfo.flags&fElement == 0 {
+		return nil, fmt.Errorf("xml: predicate %s on attribute in field %s of type %s", namePred, f.Name, typ)
+	}
+	preds, err := pathPredicates(parents)
+	if err != nil {
+		return nil, fmt.Errorf("xml: %v in field %s of type %s", err, f.Name, typ)
+	}
+	finfo.preds, finfo.namePred = preds, namePred
+	names := strings.Split(last, "|")
+	if names[0] == "" {
+		names[0] = f.Name
+	}
+	for _, name := range names[1:] {
+		if name == "" {
+			return nil, fmt.Errorf("xml: empty alias in field %s of type %s", f.Name, typ)
+		}
+	}
+	for _, parent := range parents[:len(parents)-1] {
+		if strings.Contains(parent, "|") {
+			return nil, fmt.Errorf("xml: alias in parent %q of field %s of type %s; aliases are only allowed for the last name",
+				parent, f.Name, typ)
+		}
+	}
+	finfo.name = names[0]
+	if len(names) > 1 {
+		finfo.aliases = names[1:]
+	}
 	if len(parents) > 1 {
-		if (finfo.flags & fElement) == 0 {
+		if finfo.flags&fElement == 0 && finfo.flags&fMode != fAttr {
 			return nil, fmt.Errorf("xml: %s chain not valid with %s flag", tag, strings.Join(tokens[1:], ","))
 		}
 		finfo.parents = parents[:len(parents)-1]
@@ -279,6 +549,44 @@ func (u *Utils) lookupXMLName(typ reflect.Type) (xmlname *fieldInfo) {
 	return nil
 }
 
+// hasName reports whether name is the name of finfo or one of its aliases.
+func (finfo *fieldInfo) hasName(name string) bool {
+	if finfo.name == name {
+		return true
+	}
+	for _, alias := range finfo.aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// encloses reports whether the element field finfo encloses the
+// element holding the nested attribute or text field other. Both would
+// write that element.
+func (finfo *fieldInfo) encloses(other *fieldInfo) bool {
+	if finfo.flags&fElement == 0 || other.flags&fElement != 0 || len(finfo.parents) >= len(other.parents) {
+		return false
+	}
+	for i, parent := range finfo.parents {
+		if parent != other.parents[i] {
+			return false
+		}
+	}
+	return finfo.hasStep(other.parents[len(finfo.parents)])
+}
+
+// hasAlias reports whether an alias of finfo is a name of other.
+func (finfo *fieldInfo) hasAlias(other *fieldInfo) bool {
+	for _, alias := range finfo.aliases {
+		if other.hasName(alias) {
+			return true
+		}
+	}
+	return false
+}
+
 func min(a, b int) int {
 	if a <= b {
 		return a
@@ -300,6 +608,9 @@ Loop:
 	for i := range tinfo.fields {
 		oldf := &tinfo.fields[i]
 		if oldf.flags&fMode != newf.flags&fMode {
+			if oldf.encloses(newf) || newf.encloses(oldf) {
+				conflicts = append(conflicts, i)
+			}
 			continue
 		}
 		if oldf.xmlns != "" && newf.xmlns != "" && oldf.xmlns != newf.xmlns {
@@ -312,15 +623,15 @@ Loop:
 			}
 		}
 		if len(oldf.parents) > len(newf.parents) {
-			if oldf.parents[len(newf.parents)] == newf.name {
+			if newf.hasStep(oldf.parents[len(newf.parents)]) {
 				conflicts = append(conflicts, i)
 			}
 		} else if len(oldf.parents) < len(newf.parents) {
-			if newf.parents[len(oldf.parents)] == oldf.name {
+			if oldf.hasStep(newf.parents[len(oldf.parents)]) {
 				conflicts = append(conflicts, i)
 			}
 		} else {
-			if newf.name == oldf.name {
+			if newf.namePred == oldf.namePred && (newf.hasName(oldf.name) || oldf.hasAlias(newf)) {
 				conflicts = append(conflicts, i)
 			}
 		}
@@ -345,7 +656,7 @@ Loop:
 		if len(oldf.idx) == len(newf.idx) {
 			f1 := typ.FieldByIndex(oldf.idx)
 			f2 := typ.FieldByIndex(newf.idx)
-			return &TagPathError{typ, f1.Name, f1.Tag.Get("xml"), f2.Name, f2.Tag.Get("xml")}
+			return &TagPathError{typ, f1.Name, fieldTag(&f1), f2.Name, fieldTag(&f2)}
 		}
 	}
 
@@ -372,6 +683,61 @@ func (e *TagPathError) Error() string {
 	return fmt.Sprintf("%s field %q with tag %q conflicts with field %q with tag %q", e.Struct, e.Field1, e.Tag1, e.Field2, e.Tag2)
 }
 
+// isTimeType reports whether typ is time.Time or a pointer or slice
+// holding it.
+func isTimeType(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	return typ == timeType
+}
+
+// loadLocation returns the location with the given IANA name,
+// or a fixed zone for an offset such as +03:00.
+func loadLocation(name string) (*time.Location, error) {
+	if t, err := time.Parse("-07:00", name); err == nil {
+		_, offset := t.Zone()
+		return time.FixedZone(name, offset), nil
+	}
+	return time.LoadLocation(name)
+}
+
+// formatTime formats t according to the time options of finfo.
+func (finfo *fieldInfo) formatTime(t time.Time) string {
+	if finfo.timeLoc != nil {
+		t = t.In(finfo.timeLoc)
+	}
+	switch finfo.timeFormat {
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixmilli":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return t.Format(finfo.timeFormat)
+}
+
+// parseTime parses s according to the time options of finfo.
+// Timestamps without a zone are taken to be in the timezone of finfo,
+// or in UTC.
+func (finfo *fieldInfo) parseTime(s string) (time.Time, error) {
+	loc := finfo.timeLoc
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch finfo.timeFormat {
+	case "unix", "unixmilli":
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if finfo.timeFormat == "unix" {
+			return time.Unix(n, 0).In(loc), nil
+		}
+		return time.UnixMilli(n).In(loc), nil
+	}
+	return time.ParseInLocation(finfo.timeFormat, strings.TrimSpace(s), loc)
+}
+
 // value returns v's field value corresponding to finfo.
 // It's equivalent to v.FieldByIndex(finfo.idx), but initializes
 // and dereferences pointers as necessary.
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"strconv"
 	"strings"
 	"unicode"
@@ -28,12 +29,87 @@ import (
 
 // A SyntaxError represents a syntax error in the XML input stream.
 type SyntaxError struct {
-	Msg  string
-	Line int
+	Msg    string
+	Line   int
+	Column int
 }
 
 func (e *SyntaxError) Error() string {
-	return "XML syntax error on line " + strconv.Itoa(e.Line) + ": " + e.Msg
+	if e.Column == 0 {
+		return "XML syntax error on line " + strconv.Itoa(e.Line) + ": " + e.Msg
+	}
+	return "XML syntax error on line " + strconv.Itoa(e.Line) + ", column " + strconv.Itoa(e.Column) + ": " + e.Msg
+}
+
+// A Position describes a location in the input stream. For UTF-16
+// and UTF-32 input, offsets and columns count bytes of the input
+// transcoded to UTF-8, not bytes of the input itself.
+type Position struct {
+	Offset int64 // byte offset, starting at 0
+	Line   int   // line number, starting at 1
+	Column int   // column number in bytes, starting at 1
+}
+
+// A SourceRange describes the part of the input stream that a token,
+// element or attribute was read from.
+type SourceRange struct {
+	Start, End Position
+}
+
+// A Declaration holds the pseudo-attributes of an XML declaration
+// such as <?xml version="1.0" encoding="UTF-8" standalone="yes"?>.
+// Empty fields are absent from the declaration.
+type Declaration struct {
+	Version    string
+	Encoding   string
+	Standalone string
+}
+
+// ProcInst returns the declaration as a processing instruction
+// suitable for Encoder.EncodeToken. An empty Version is written as "1.0".
+func (decl Declaration) ProcInst() xml.ProcInst {
+	version := decl.Version
+	if version == "" {
+		version = "1.0"
+	}
+	inst := `version="` + version + `"`
+	if decl.Encoding != "" {
+		inst += ` encoding="` + decl.Encoding + `"`
+	}
+	if decl.Standalone != "" {
+		inst += ` standalone="` + decl.Standalone + `"`
+	}
+	return xml.ProcInst{Target: "xml", Inst: []byte(inst)}
+}
+
+// validate returns an error if a field of decl does not have the form
+// the XML specification gives for it.
+func (decl Declaration) validate() error {
+	if v := decl.Version; v != "" && (!strings.HasPrefix(v, "1.") || len(v) == 2 || strings.Trim(v[2:], "0123456789") != "") {
+		return fmt.Errorf("xml: invalid version %q in Declaration", v)
+	}
+	if e := decl.Encoding; e != "" && !isEncodingName(e) {
+		return fmt.Errorf("xml: invalid encoding %q in Declaration", e)
+	}
+	if s := decl.Standalone; s != "" && s != "yes" && s != "no" {
+		return fmt.Errorf("xml: invalid standalone %q in Declaration, want yes or no", s)
+	}
+	return nil
+}
+
+// isEncodingName reports whether s is a valid encoding name: a letter
+// followed by letters, digits, '.', '_' and '-'.
+func isEncodingName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '.' || c == '_' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return s != ""
 }
 
 // A Decoder represents an XML parser reading a particular input stream.
@@ -83,6 +159,7 @@ type Decoder struct {
 	// non-UTF-8 charset into UTF-8. If CharsetReader is nil or
 	// returns an error, parsing stops with an error. One of the
 	// CharsetReader's result values must be non-nil.
+	// NewDecoder sets it to the package-level CharsetReader.
 	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
 
 	// DefaultSpace sets the default name space used for unadorned tags,
@@ -90,6 +167,41 @@ type Decoder struct {
 	// the attribute xmlns="DefaultSpace".
 	DefaultSpace string
 
+	// CollectErrors, if true, makes Decode keep going after an element
+	// or attribute value that cannot be converted or fails validation.
+	// The offending value is skipped, the problem is recorded together
+	// with its path, and once the element is fully consumed Decode
+	// returns all recorded problems as an ErrorList.
+	CollectErrors bool
+
+	// TrackPositions, if true, makes the decoder record where each
+	// token starts and ends; see TokenPos. Errors produced while
+	// unmarshaling values are then PathErrors carrying the position
+	// of the offending element or attribute.
+	TrackPositions bool
+
+	// SourceMap, if non-nil, receives the input range of every element
+	// and attribute that Decode stores in a struct field, keyed by the
+	// Go field path such as "Items[1].Price". Setting SourceMap
+	// implies TrackPositions.
+	SourceMap SourceMap
+
+	// Unconsumed, if non-nil, receives the path of every element and
+	// attribute that Decode skipped because no value maps it, along
+	// with the number of times it was skipped. Paths have the form
+	// used by PathError.
+	Unconsumed UnconsumedReport
+
+	// CaseInsensitive, if true, makes Decode match element and attribute
+	// names against the names in struct tags without regard to case, so
+	// that <OrderId> fills a field tagged OrderID.
+	CaseInsensitive bool
+
+	// NormalizeName, if non-nil, maps element and attribute names and
+	// the names in struct tags before Decode compares them. For instance
+	// StripSeparators makes <order-id> fill a field tagged order_id.
+	NormalizeName func(name string) string
+
 	r              io.ByteReader
 	t              xml.TokenReader
 	buf            bytes.Buffer
@@ -103,8 +215,20 @@ type Decoder struct {
 	ns             map[string]string
 	err            error
 	line           int
+	linestart      int64
 	offset         int64
+	tokStart       Position
+	tokEnd         Position
+	attrPos        []SourceRange
+	fields         []string
+	decl           *Declaration
+	sniffed        bool
+	codecs         map[reflect.Type]*codec
+	types          map[xml.Name]reflect.Type
+	xsiTypes       map[xml.Name]reflect.Type
+	encoding       string // encoding detected from the first bytes
 	unmarshalDepth int
+	errs           ErrorList
 }
 
 // NewDecoder creates a new XML parser reading from r.
@@ -117,6 +241,7 @@ func NewDecoder(r io.Reader) *Decoder {
 		line:     1,
 		Strict:   true,
 	}
+	d.CharsetReader = CharsetReader
 	d.switchToReader(r)
 	return d
 }
@@ -262,6 +387,81 @@ func (d *Decoder) switchToReader(r io.Reader) {
 	}
 }
 
+// encodingSignatures are the byte order marks and the first bytes
+// of an XML declaration that identify the encoding of the input, as
+// described in Appendix F of the XML specification. A signature that
+// is a prefix of another comes after it.
+var encodingSignatures = []struct {
+	sig      []byte
+	encoding string
+	bom      bool // sig is a byte order mark rather than content
+	le       bool
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, "UTF-8", true, false},
+	{[]byte{0x00, 0x00, 0xFE, 0xFF}, "UTF-32BE", true, false},
+	{[]byte{0xFF, 0xFE, 0x00, 0x00}, "UTF-32LE", true, true},
+	{[]byte{0xFE, 0xFF}, "UTF-16BE", true, false},
+	{[]byte{0xFF, 0xFE}, "UTF-16LE", true, true},
+	{[]byte{0x00, 0x00, 0x00, '<'}, "UTF-32BE", false, false},
+	{[]byte{'<', 0x00, 0x00, 0x00}, "UTF-32LE", false, true},
+	{[]byte{0x00, '<', 0x00, '?'}, "UTF-16BE", false, false},
+	{[]byte{'<', 0x00, '?', 0x00}, "UTF-16LE", false, true},
+}
+
+// mayBeSignature reports whether b is the start of a longer encoding
+// signature, so that reading another byte could identify the encoding.
+func mayBeSignature(b []byte) bool {
+	for _, s := range encodingSignatures {
+		if len(b) < len(s.sig) && bytes.HasPrefix(s.sig, b) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniff detects the encoding of the input from a byte order mark or
+// the first characters of an XML declaration. It reads no more bytes
+// than needed to tell, so that a short first token on a stream that
+// has nothing more to send yet is still returned. UTF-16 and UTF-32
+// input is transcoded to UTF-8, and any encoding named by the
+// declaration is ignored afterwards. Other input is left as it is.
+func (d *Decoder) sniff() {
+	var buf [4]byte
+	n := 0
+	var err error
+	for n < len(buf) && mayBeSignature(buf[:n]) {
+		if buf[n], err = d.r.ReadByte(); err != nil {
+			break
+		}
+		n++
+	}
+	b := buf[:n]
+	le := false
+	skip := 0
+	for _, s := range encodingSignatures {
+		if bytes.HasPrefix(b, s.sig) {
+			d.encoding, le = s.encoding, s.le
+			if s.bom {
+				skip = len(s.sig)
+			}
+			break
+		}
+	}
+	r := &prefixReader{prefix: append([]byte(nil), b[skip:]...), r: d.r, err: err}
+	switch d.encoding {
+	case "":
+		d.r = r
+	case "UTF-8":
+		// Keep offsets in terms of the original input.
+		d.offset, d.linestart = int64(skip), int64(skip)
+		d.r = r
+	case "UTF-16BE", "UTF-16LE":
+		d.switchToReader(&utf16Reader{r: r, le: le})
+	default:
+		d.switchToReader(&utf32Reader{r: r, le: le})
+	}
+}
+
 // Parsing state - stack holds old name space translations
 // and the current set of open elements. The translations to pop when
 // ending a given tag are *below* it on the stack, which is
@@ -271,6 +471,7 @@ type stack struct {
 	kind int
 	name xml.Name
 	ok   bool
+	pos  Position
 }
 
 const (
@@ -343,6 +544,7 @@ func (d *Decoder) popEOF() bool {
 func (d *Decoder) pushElement(name xml.Name) {
 	s := d.push(stkStart)
 	s.name = name
+	s.pos = d.tokStart
 }
 
 // Record that we are changing the value of ns[local].
@@ -354,9 +556,42 @@ func (d *Decoder) pushNs(local string, url string, ok bool) {
 	s.ok = ok
 }
 
-// Creates a SyntaxError with the current line number.
+// path returns the slash-separated names of the currently open
+// elements, outermost first, followed by names.
+func (d *Decoder) path(names ...string) string {
+	var open []string
+	for s := d.stk; s != nil; s = s.next {
+		if s.kind == stkStart {
+			open = append(open, s.name.Local)
+		}
+	}
+	var b strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteByte('/')
+		b.WriteString(open[i])
+	}
+	for _, name := range names {
+		b.WriteByte('/')
+		b.WriteString(name)
+	}
+	return b.String()
+}
+
+// elementPos returns the start position of the innermost open element.
+// It is only known when TrackPositions is set.
+func (d *Decoder) elementPos() Position {
+	for s := d.stk; s != nil; s = s.next {
+		if s.kind == stkStart {
+			return s.pos
+		}
+	}
+	return Position{}
+}
+
+// Creates a SyntaxError with the current line and column numbers.
 func (d *Decoder) syntaxError(msg string) error {
-	return &SyntaxError{Msg: msg, Line: d.line}
+	line, column := d.InputPos()
+	return &SyntaxError{Msg: msg, Line: line, Column: column}
 }
 
 // Record that we are ending an element with the given name.
@@ -440,6 +675,16 @@ func (d *Decoder) rawToken() (xml.Token, error) {
 	if d.err != nil {
 		return nil, d.err
 	}
+	if !d.sniffed {
+		d.sniffed = true
+		d.sniff()
+	}
+	if d.tracking() {
+		start := d.pos()
+		defer func() {
+			d.tokStart, d.tokEnd = start, d.pos()
+		}()
+	}
 	if d.needClose {
 		// The last element we read was self-closing and
 		// we returned just the StartElement half.
@@ -519,7 +764,12 @@ func (d *Decoder) rawToken() (xml.Token, error) {
 				return nil, d.err
 			}
 			enc := procInst("encoding", content)
-			if enc != "" && enc != "utf-8" && enc != "UTF-8" && !strings.EqualFold(enc, "utf-8") {
+			d.decl = &Declaration{
+				Version:    ver,
+				Encoding:   enc,
+				Standalone: procInst("standalone", content),
+			}
+			if d.encoding == "" && enc != "" && enc != "utf-8" && enc != "UTF-8" && !strings.EqualFold(enc, "utf-8") {
 				if d.CharsetReader == nil {
 					d.err = fmt.Errorf("xml: encoding %q declared but Decoder.CharsetReader is nil", enc)
 					return nil, d.err
@@ -673,6 +923,9 @@ func (d *Decoder) rawToken() (xml.Token, error) {
 	}
 
 	attr = []xml.Attr{}
+	if d.tracking() {
+		d.attrPos = d.attrPos[:0]
+	}
 	for {
 		d.space()
 		if b, ok = d.mustgetc(); !ok {
@@ -695,6 +948,7 @@ func (d *Decoder) rawToken() (xml.Token, error) {
 		d.ungetc(b)
 
 		a := xml.Attr{}
+		attrStart := d.pos()
 		if a.Name, ok = d.nsname(); !ok {
 			if d.err == nil {
 				d.err = d.syntaxError("expected attribute name in element")
@@ -721,6 +975,9 @@ func (d *Decoder) rawToken() (xml.Token, error) {
 			a.Value = string(data)
 		}
 		attr = append(attr, a)
+		if d.tracking() {
+			d.attrPos = append(d.attrPos, SourceRange{Start: attrStart, End: d.pos()})
+		}
 	}
 	if empty {
 		d.needClose = true
@@ -801,6 +1058,7 @@ func (d *Decoder) getc() (b byte, ok bool) {
 	}
 	if b == '\n' {
 		d.line++
+		d.linestart = d.offset + 1
 	}
 	d.offset++
 	return b, true
@@ -808,11 +1066,44 @@ func (d *Decoder) getc() (b byte, ok bool) {
 
 // InputOffset returns the input stream byte offset of the current decoder position.
 // The offset gives the location of the end of the most recently returned token
-// and the beginning of the next token.
+// and the beginning of the next token. For UTF-16 and UTF-32 input, the
+// offset counts bytes of the input transcoded to UTF-8.
 func (d *Decoder) InputOffset() int64 {
 	return d.offset
 }
 
+// Declaration returns the XML declaration read from the input stream,
+// or nil if no declaration has been read so far.
+func (d *Decoder) Declaration() *Declaration {
+	return d.decl
+}
+
+// InputPos returns the line of the current decoder position and the 1 based
+// input position of the line. The position gives the location of the end of the
+// most recently returned token. As with InputOffset, the column counts bytes
+// of the transcoded input for UTF-16 and UTF-32 input.
+func (d *Decoder) InputPos() (line, column int) {
+	return d.line, int(d.offset-d.linestart) + 1
+}
+
+// TokenPos returns the positions where the most recently returned
+// token starts and ends. It requires TrackPositions to be set before
+// the token is read and reports zero Positions otherwise, as well as
+// for tokens taken from an underlying xml.TokenReader.
+func (d *Decoder) TokenPos() (start, end Position) {
+	return d.tokStart, d.tokEnd
+}
+
+// tracking reports whether token positions need to be recorded.
+func (d *Decoder) tracking() bool {
+	return d.TrackPositions || d.SourceMap != nil
+}
+
+func (d *Decoder) pos() Position {
+	line, column := d.InputPos()
+	return Position{Offset: d.offset, Line: line, Column: column}
+}
+
 // Return saved offset.
 // If we did ungetc (nextByte >= 0), have to back up one.
 func (d *Decoder) savedOffset() int {
@@ -1785,6 +2076,7 @@ func EscapeText(w io.Writer, s []byte) error {
 // characters will be escaped.
 func escapeText(w io.Writer, s []byte, escapeNewline bool) error {
 	var esc []byte
+	enc := outputEncoder(w)
 	last := 0
 	for i := 0; i < len(s); {
 		r, width := utf8.DecodeRune(s[i:])
@@ -1814,6 +2106,10 @@ func escapeText(w io.Writer, s []byte, escapeNewline bool) error {
 				esc = escFFFD
 				break
 			}
+			if enc != nil && r >= utf8.RuneSelf && !enc.CanEncode(r) {
+				esc = appendCharRef(nil, r)
+				break
+			}
 			continue
 		}
 		if _, err := w.Write(s[last : i-width]); err != nil {
@@ -1858,6 +2154,10 @@ func (p *printer) EscapeString(s string) {
 				esc = escFFFD
 				break
 			}
+			if p.charset != nil && r >= utf8.RuneSelf && !p.charset.enc.CanEncode(r) {
+				esc = appendCharRef(nil, r)
+				break
+			}
 			continue
 		}
 		p.WriteString(s[last : i-width])
@@ -12,6 +12,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
@@ -899,3 +901,217 @@ func TestTokenUnmarshaler(t *testing.T) {
 	d := NewTokenDecoder(tokReader{})
 	d.Decode(&Failure{})
 }
+
+func TestInputPos(t *testing.T) {
+	d := NewDecoder(strings.NewReader("<a>\n  <b>x</b>\n</a>"))
+	want := [][2]int{{1, 4}, {2, 3}, {2, 6}, {2, 7}, {2, 11}, {3, 1}, {3, 5}}
+	for i, w := range want {
+		if _, err := d.Token(); err != nil {
+			t.Fatalf("token %d: %v", i, err)
+		}
+		if line, column := d.InputPos(); line != w[0] || column != w[1] {
+			t.Errorf("token %d: InputPos() = %d, %d, want %d, %d", i, line, column, w[0], w[1])
+		}
+	}
+}
+
+func TestTokenPos(t *testing.T) {
+	d := NewDecoder(strings.NewReader("<a>\n  <b k=\"v\"/>\n</a>"))
+	d.TrackPositions = true
+	want := []struct {
+		tok        xml.Token
+		start, end Position
+	}{
+		{xml.StartElement{Name: xml.Name{Local: "a"}, Attr: []xml.Attr{}}, Position{0, 1, 1}, Position{3, 1, 4}},
+		{xml.CharData("\n  "), Position{3, 1, 4}, Position{6, 2, 3}},
+		{xml.StartElement{Name: xml.Name{Local: "b"}, Attr: []xml.Attr{{Name: xml.Name{Local: "k"}, Value: "v"}}}, Position{6, 2, 3}, Position{16, 2, 13}},
+		{xml.EndElement{Name: xml.Name{Local: "b"}}, Position{16, 2, 13}, Position{16, 2, 13}},
+		{xml.CharData("\n"), Position{16, 2, 13}, Position{17, 3, 1}},
+		{xml.EndElement{Name: xml.Name{Local: "a"}}, Position{17, 3, 1}, Position{21, 3, 5}},
+	}
+	for i, w := range want {
+		tok, err := d.Token()
+		if err != nil {
+			t.Fatalf("token %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(tok, w.tok) {
+			t.Fatalf("token %d = %#v, want %#v", i, tok, w.tok)
+		}
+		if start, end := d.TokenPos(); start != w.start || end != w.end {
+			t.Errorf("token %d: TokenPos() = %+v, %+v, want %+v, %+v", i, start, end, w.start, w.end)
+		}
+	}
+}
+
+func TestSyntaxErrorColumn(t *testing.T) {
+	d := NewDecoder(strings.NewReader("<a>\n  <b></c>\n</a>"))
+	var err error
+	for err == nil {
+		_, err = d.Token()
+	}
+	synerr, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("Token() = _, %v, want *SyntaxError", err)
+	}
+	if synerr.Line != 2 || synerr.Column != 10 {
+		t.Errorf("SyntaxError at %d:%d, want 2:10", synerr.Line, synerr.Column)
+	}
+	if !strings.Contains(err.Error(), "line 2, column 10:") {
+		t.Errorf("Error() = %q, want line and column", err)
+	}
+}
+
+func TestPathErrorPos(t *testing.T) {
+	type T struct {
+		A int `xml:"a"`
+		B int `xml:"b,attr"`
+	}
+	d := NewDecoder(strings.NewReader("<t b=\"x\">\n  <a>y</a>\n</t>"))
+	d.TrackPositions = true
+	d.CollectErrors = true
+	var v T
+	errs, ok := d.Decode(&v).(ErrorList)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Decode returned %v, want two errors", errs)
+	}
+	if errs[0].Path != "/t/@b" || errs[0].Pos != (Position{3, 1, 4}) {
+		t.Errorf("first error = %s at %+v", errs[0].Path, errs[0].Pos)
+	}
+	if errs[1].Path != "/t/a" || errs[1].Pos != (Position{12, 2, 3}) {
+		t.Errorf("second error = %s at %+v", errs[1].Path, errs[1].Pos)
+	}
+
+	// Without CollectErrors, name mismatches still carry a location.
+	type N struct {
+		XMLName xml.Name `xml:"n"`
+	}
+	d = NewDecoder(strings.NewReader("\n<m/>"))
+	d.TrackPositions = true
+	err := d.Decode(new(N))
+	perr, ok := err.(*PathError)
+	if !ok || perr.Pos.Line != 2 {
+		t.Fatalf("Decode = %#v, want *PathError on line 2", err)
+	}
+	if _, ok := perr.Err.(UnmarshalError); !ok {
+		t.Errorf("wrapped error is %T, want UnmarshalError", perr.Err)
+	}
+
+	// So do conversion errors.
+	d = NewDecoder(strings.NewReader("<t>\n  <a>y</a>\n</t>"))
+	d.TrackPositions = true
+	err = d.Decode(new(T))
+	perr, ok = err.(*PathError)
+	if !ok || perr.Path != "/t/a" || perr.Pos != (Position{6, 2, 3}) {
+		t.Errorf("Decode = %v, want *PathError for /t/a at line 2, column 3", err)
+	}
+}
+
+func TestDecoderDeclaration(t *testing.T) {
+	tests := []struct {
+		input string
+		want  *Declaration
+	}{
+		{`<a/>`, nil},
+		{`<?xml version="1.0"?><a/>`, &Declaration{Version: "1.0"}},
+		{`<?xml version="1.0" encoding="UTF-8" standalone="no"?><a/>`, &Declaration{Version: "1.0", Encoding: "UTF-8", Standalone: "no"}},
+	}
+	for _, tt := range tests {
+		d := NewDecoder(strings.NewReader(tt.input))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%q: %v", tt.input, err)
+			}
+		}
+		if got := d.Declaration(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: Declaration() = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func encodeUTF16(s string, le, bom bool) string {
+	var b []byte
+	units := utf16.Encode([]rune(s))
+	if bom {
+		units = append([]uint16{0xFEFF}, units...)
+	}
+	for _, u := range units {
+		if le {
+			b = append(b, byte(u), byte(u>>8))
+		} else {
+			b = append(b, byte(u>>8), byte(u))
+		}
+	}
+	return string(b)
+}
+
+func encodeUTF32(s string, le bool) string {
+	var b []byte
+	for _, r := range s {
+		if le {
+			b = append(b, byte(r), byte(r>>8), byte(r>>16), byte(r>>24))
+		} else {
+			b = append(b, byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	return string(b)
+}
+
+func TestDecodeSniffEncoding(t *testing.T) {
+	const doc = `<?xml version="1.0" encoding="UTF-16"?><a x="ÿ">Привет 😀</a>`
+	const plain = `<a x="ÿ">Привет 😀</a>`
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"UTF-8 BOM", "\xef\xbb\xbf" + plain},
+		{"UTF-16LE BOM", encodeUTF16(doc, true, true)},
+		{"UTF-16BE BOM", encodeUTF16(plain, false, true)},
+		{"UTF-16LE", encodeUTF16(doc, true, false)},
+		{"UTF-16BE", encodeUTF16(doc, false, false)},
+		{"UTF-32LE BOM", encodeUTF32("\ufeff"+plain, true)},
+		{"UTF-32BE", encodeUTF32(doc, false)},
+		{"UTF-32LE", encodeUTF32(doc, true)},
+	}
+	for _, tt := range tests {
+		var v struct {
+			X    string `xml:"x,attr"`
+			Text string `xml:",chardata"`
+		}
+		if err := NewDecoder(strings.NewReader(tt.input)).Decode(&v); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if v.X != "ÿ" || v.Text != "Привет 😀" {
+			t.Errorf("%s: got %+v", tt.name, v)
+		}
+	}
+
+	// Offsets after a UTF-8 byte order mark refer to the original input.
+	d := NewDecoder(strings.NewReader("\xef\xbb\xbf<a/>"))
+	if _, err := d.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if off := d.InputOffset(); off != 7 {
+		t.Errorf("InputOffset after UTF-8 BOM = %d, want 7", off)
+	}
+
+	// A short first token is returned before more input arrives.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write([]byte("<a>"))
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewDecoder(pr).Token()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Token on stream: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Token on stream blocked waiting for more input")
+	}
+}